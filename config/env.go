@@ -1,7 +1,12 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"gin-real-time-talk/pkg/logger"
 
@@ -36,15 +41,58 @@ type SMTPConfig struct {
 	From     string
 }
 
+type ChatConfig struct {
+	MessageEditWindow time.Duration
+}
+
+type UploadConfig struct {
+	Dir          string
+	MaxSize      int64
+	AllowedTypes []string
+}
+
+// CORSConfig lists the browser origins allowed to call the API and open
+// sockets. AllowEmptyOrigin also admits socket upgrades without an Origin
+// header, as sent by native clients; browsers always send one.
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowEmptyOrigin bool
+}
+
+type WebSocketConfig struct {
+	Backplane            string
+	HubShards            int64
+	HubInboxSize         int64
+	HubOverflow          string
+	SendQueueSize        int64
+	SlowConsumerPolicies []string
+	MaxConnsPerUser      int64
+	MaxConnsPerIP        int64
+}
+
+// DebugConfig enables the debug listener that serves /debug/vars. It is off
+// unless Addr is set, and the listener has no authentication, so Addr must be
+// a loopback or otherwise internal address such as 127.0.0.1:6060.
+type DebugConfig struct {
+	Addr string
+}
+
 type Config struct {
-	App  AppConfig
-	DB   DBConfig
-	JWT  JWConfig
-	SMTP SMTPConfig
+	App       AppConfig
+	DB        DBConfig
+	JWT       JWConfig
+	SMTP      SMTPConfig
+	Chat      ChatConfig
+	Upload    UploadConfig
+	CORS      CORSConfig
+	WebSocket WebSocketConfig
+	Debug     DebugConfig
 }
 
 var Env *Config
 
+var invalidValues []error
+
 func init() {
 	log := logger.New()
 	err := godotenv.Load()
@@ -78,6 +126,31 @@ func init() {
 			Password: getEnv("SMTP_PASSWORD", ""),
 			From:     getEnv("SMTP_FROM", ""),
 		},
+		Chat: ChatConfig{
+			MessageEditWindow: getEnvDuration("MESSAGE_EDIT_WINDOW", 48*time.Hour),
+		},
+		Upload: UploadConfig{
+			Dir:          getEnv("UPLOAD_DIR", "./uploads"),
+			MaxSize:      getEnvInt64("UPLOAD_MAX_SIZE", 20*1024*1024),
+			AllowedTypes: getEnvList("UPLOAD_ALLOWED_TYPES", "image/,video/,audio/,application/pdf,application/zip,text/plain"),
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   getEnvList("CORS_ALLOWED_ORIGINS", "http://localhost:3000,http://localhost:3001"),
+			AllowEmptyOrigin: getEnvBool("CORS_ALLOW_EMPTY_ORIGIN", false),
+		},
+		WebSocket: WebSocketConfig{
+			Backplane:            getEnv("WS_BACKPLANE", "memory"),
+			HubShards:            getEnvInt64("WS_HUB_SHARDS", 0),
+			HubInboxSize:         getEnvInt64("WS_HUB_INBOX_SIZE", 4096),
+			HubOverflow:          getEnv("WS_HUB_OVERFLOW", "resync"),
+			SendQueueSize:        getEnvInt64("WS_SEND_QUEUE_SIZE", 256),
+			SlowConsumerPolicies: getEnvList("WS_SLOW_CONSUMER_POLICIES", "typing=drop_oldest,presence=coalesce"),
+			MaxConnsPerUser:      getEnvInt64("WS_MAX_CONNECTIONS_PER_USER", 10),
+			MaxConnsPerIP:        getEnvInt64("WS_MAX_CONNECTIONS_PER_IP", 50),
+		},
+		Debug: DebugConfig{
+			Addr: getEnv("DEBUG_ADDR", ""),
+		},
 	}
 }
 
@@ -90,3 +163,70 @@ func getEnv(key, defaultValue string) string {
 
 	return value
 }
+
+// getEnvInt64 parses an integer such as "1048576". An unparsable value is
+// reported by Validate.
+func getEnvInt64(key string, defaultValue int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		invalidValues = append(invalidValues, fmt.Errorf("invalid %s %q: expected an integer", key, value))
+		return defaultValue
+	}
+
+	return parsed
+}
+
+// getEnvDuration parses a duration such as "48h". An unparsable value is
+// reported by Validate rather than silently replaced with the default.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		invalidValues = append(invalidValues, fmt.Errorf("invalid %s %q: expected a positive duration such as 48h", key, value))
+		return defaultValue
+	}
+
+	return duration
+}
+
+// getEnvBool parses a boolean such as "true" or "0". An unparsable value is
+// reported by Validate.
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		invalidValues = append(invalidValues, fmt.Errorf("invalid %s %q: expected true or false", key, value))
+		return defaultValue
+	}
+
+	return parsed
+}
+
+// Validate reports the environment values that could not be parsed.
+func Validate() error {
+	return errors.Join(invalidValues...)
+}
+
+func getEnvList(key, defaultValue string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, defaultValue), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
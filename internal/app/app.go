@@ -1,22 +1,36 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
+	"gin-real-time-talk/config"
 	v1 "gin-real-time-talk/internal/controller/http/v1"
+	"gin-real-time-talk/internal/usecase/attachment_usecase"
+	"gin-real-time-talk/internal/usecase/repository"
 	"gin-real-time-talk/pkg/httpserver"
 	"gin-real-time-talk/pkg/logger"
 	"gin-real-time-talk/pkg/postgres"
+	"gin-real-time-talk/pkg/storage"
 	"gin-real-time-talk/pkg/validator"
+	"gin-real-time-talk/pkg/websocket"
+
+	"gorm.io/gorm"
 )
 
 func Run() error {
 	validator.Init()
 	logger := logger.New()
 
+	if err := config.Validate(); err != nil {
+		logger.Error(fmt.Sprintf("Invalid configuration: %v", err))
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
 	db, err := postgres.New()
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to initialize database: %v", err))
@@ -28,7 +42,34 @@ func Run() error {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
-	handler := v1.NewRouter(db, logger)
+	backplane, err := newBackplane(db)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to initialize websocket backplane: %v", err))
+		return fmt.Errorf("failed to initialize websocket backplane: %w", err)
+	}
+	defer backplane.Close()
+
+	hubConfig, err := newHubConfig()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to initialize websocket hub: %v", err))
+		return fmt.Errorf("failed to initialize websocket hub: %w", err)
+	}
+
+	hub, err := websocket.NewHub(backplane, hubConfig)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to initialize websocket hub: %v", err))
+		return fmt.Errorf("failed to initialize websocket hub: %w", err)
+	}
+	go hub.Run()
+	defer hub.Stop()
+
+	fileStorage := storage.NewLocalStorage(config.Env.Upload.Dir)
+	thumbnailWorker := attachment_usecase.NewThumbnailWorker(repository.NewAttachmentRepository(db), fileStorage)
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	go thumbnailWorker.Run(workerCtx)
+	defer stopWorker()
+
+	handler := v1.NewRouter(db, logger, hub, fileStorage, thumbnailWorker)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -36,6 +77,14 @@ func Run() error {
 	}
 	httpServer := httpserver.New(handler, httpserver.Port(port))
 
+	var debugServer *httpserver.Server
+	var debugNotify <-chan error
+	if config.Env.Debug.Addr != "" {
+		debugServer = httpserver.New(v1.NewDebugHandler(hub), httpserver.Addr(config.Env.Debug.Addr))
+		debugNotify = debugServer.Notify()
+		logger.Info(fmt.Sprintf("Debug listener enabled on %s", config.Env.Debug.Addr))
+	}
+
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 
@@ -44,6 +93,14 @@ func Run() error {
 		logger.Info(fmt.Sprintf("signal: %s", s.String()))
 	case err = <-httpServer.Notify():
 		logger.Error(fmt.Sprintf("httpServer.Notify: %v", err))
+	case err = <-debugNotify:
+		logger.Error(fmt.Sprintf("debugServer.Notify: %v", err))
+	}
+
+	if debugServer != nil {
+		if err := debugServer.Shutdown(); err != nil {
+			logger.Error(fmt.Sprintf("debugServer.Shutdown: %v", err))
+		}
 	}
 
 	err = httpServer.Shutdown()
@@ -54,3 +111,48 @@ func Run() error {
 
 	return nil
 }
+
+func newBackplane(db *gorm.DB) (websocket.Backplane, error) {
+	switch config.Env.WebSocket.Backplane {
+	case "memory":
+		return websocket.NewMemoryBackplane(), nil
+	case "postgres":
+		return websocket.NewPostgresBackplane(db, postgres.DSN()), nil
+	default:
+		return nil, fmt.Errorf("unknown websocket backplane %q", config.Env.WebSocket.Backplane)
+	}
+}
+
+func newHubConfig() (websocket.HubConfig, error) {
+	hubConfig := websocket.HubConfig{
+		Shards:        int(config.Env.WebSocket.HubShards),
+		InboxSize:     int(config.Env.WebSocket.HubInboxSize),
+		SendQueueSize: int(config.Env.WebSocket.SendQueueSize),
+		Policies:      make(map[string]websocket.SlowConsumerPolicy),
+	}
+
+	switch config.Env.WebSocket.HubOverflow {
+	case "resync":
+		hubConfig.Overflow = websocket.OverflowResync
+	case "block":
+		hubConfig.Overflow = websocket.OverflowBlock
+	default:
+		return hubConfig, fmt.Errorf("unknown websocket hub overflow policy %q", config.Env.WebSocket.HubOverflow)
+	}
+
+	for _, entry := range config.Env.WebSocket.SlowConsumerPolicies {
+		eventType, name, _ := strings.Cut(entry, "=")
+		switch name {
+		case "disconnect":
+			hubConfig.Policies[eventType] = websocket.SlowConsumerDisconnect
+		case "drop_oldest":
+			hubConfig.Policies[eventType] = websocket.SlowConsumerDropOldest
+		case "coalesce":
+			hubConfig.Policies[eventType] = websocket.SlowConsumerCoalesce
+		default:
+			return hubConfig, fmt.Errorf("unknown slow consumer policy %q for %s events", name, eventType)
+		}
+	}
+
+	return hubConfig, nil
+}
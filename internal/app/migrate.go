@@ -13,9 +13,183 @@ func Migrate(db *gorm.DB) error {
 		return fmt.Errorf("database connection is not initialized")
 	}
 
-	return db.AutoMigrate(
+	if err := db.AutoMigrate(
 		&entity.User{},
 		&entity.Chat{},
+		&entity.ChatMember{},
 		&entity.Message{},
-	)
+		&entity.MessageEdit{},
+		&entity.HiddenMessage{},
+		&entity.MessageReaction{},
+		&entity.PinnedMessage{},
+		&entity.Attachment{},
+	); err != nil {
+		return err
+	}
+
+	if err := backfillChatMembers(db); err != nil {
+		return err
+	}
+
+	if err := mergeDirectChats(db); err != nil {
+		return err
+	}
+
+	return dropLegacyReadFlags(db)
+}
+
+// backfillChatMembers moves chats created before chat_members existed onto the
+// membership table: the former chats.user_id owner and every message author
+// become members. Legacy chats never recorded the recipient, so the owner
+// column is kept as legacy_user_id rather than dropped.
+func backfillChatMembers(db *gorm.DB) error {
+	if !db.Migrator().HasColumn("chats", "user_id") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`
+			INSERT INTO chat_members (chat_id, user_id, role, joined_at)
+			SELECT chats.id, chats.user_id, ?, chats.created_at
+			FROM chats
+			WHERE chats.user_id IS NOT NULL
+			ON CONFLICT DO NOTHING
+		`, entity.ChatMemberRoleMember).Error; err != nil {
+			return fmt.Errorf("failed to backfill chat owners: %w", err)
+		}
+
+		if err := tx.Exec(`
+			INSERT INTO chat_members (chat_id, user_id, role, joined_at)
+			SELECT messages.chat_id, messages.author_id, ?, MIN(messages.created_at)
+			FROM messages
+			GROUP BY messages.chat_id, messages.author_id
+			ON CONFLICT DO NOTHING
+		`, entity.ChatMemberRoleMember).Error; err != nil {
+			return fmt.Errorf("failed to backfill message authors: %w", err)
+		}
+
+		if err := tx.Exec("ALTER TABLE chats RENAME COLUMN user_id TO legacy_user_id").Error; err != nil {
+			return fmt.Errorf("failed to rename chats.user_id: %w", err)
+		}
+
+		if err := tx.Exec("ALTER TABLE chats ALTER COLUMN legacy_user_id DROP NOT NULL").Error; err != nil {
+			return fmt.Errorf("failed to relax chats.legacy_user_id: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// mergeDirectChats folds direct chats between the same two users into the
+// oldest one and gives it its direct_key. Such duplicates come from legacy
+// per-sender chats and from concurrent first messages before direct_key
+// existed. Messages, pins and read cursors move over to the kept chat.
+func mergeDirectChats(db *gorm.DB) error {
+	// Only chats with two members can be keyed, so legacy chats with a single
+	// member stay unkeyed and must not trigger the merge on every start.
+	var pending int64
+	if err := db.Model(&entity.Chat{}).
+		Where("type = ? AND direct_key IS NULL", entity.ChatTypeDirect).
+		Where("(SELECT COUNT(*) FROM chat_members WHERE chat_members.chat_id = chats.id) = 2").
+		Count(&pending).Error; err != nil {
+		return err
+	}
+	if pending == 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		steps := []struct {
+			name  string
+			query string
+		}{
+			{"collect direct chat pairs", `
+				CREATE TEMP TABLE direct_chat_merges ON COMMIT DROP AS
+				SELECT pairs.chat_id, pairs.direct_key,
+					MIN(pairs.chat_id) OVER (PARTITION BY pairs.direct_key) AS target_id
+				FROM (
+					SELECT chat_members.chat_id,
+						MIN(chat_members.user_id) || ':' || MAX(chat_members.user_id) AS direct_key
+					FROM chat_members
+					JOIN chats ON chats.id = chat_members.chat_id AND chats.type = 'direct'
+					GROUP BY chat_members.chat_id
+					HAVING COUNT(*) = 2
+				) pairs`},
+			{"move messages", `
+				UPDATE messages SET chat_id = merges.target_id
+				FROM direct_chat_merges merges
+				WHERE messages.chat_id = merges.chat_id AND merges.chat_id <> merges.target_id`},
+			{"move pins", `
+				UPDATE pinned_messages SET chat_id = merges.target_id
+				FROM direct_chat_merges merges
+				WHERE pinned_messages.chat_id = merges.chat_id AND merges.chat_id <> merges.target_id`},
+			{"merge read cursors", `
+				UPDATE chat_members AS target SET
+					last_read_message_id = NULLIF(GREATEST(COALESCE(target.last_read_message_id, 0), COALESCE(duplicate.last_read_message_id, 0)), 0),
+					last_delivered_message_id = NULLIF(GREATEST(COALESCE(target.last_delivered_message_id, 0), COALESCE(duplicate.last_delivered_message_id, 0)), 0)
+				FROM chat_members duplicate
+				JOIN direct_chat_merges merges ON merges.chat_id = duplicate.chat_id AND merges.chat_id <> merges.target_id
+				WHERE target.chat_id = merges.target_id AND target.user_id = duplicate.user_id`},
+			{"remove duplicate members", `
+				DELETE FROM chat_members USING direct_chat_merges merges
+				WHERE chat_members.chat_id = merges.chat_id AND merges.chat_id <> merges.target_id`},
+			{"remove duplicate chats", `
+				DELETE FROM chats USING direct_chat_merges merges
+				WHERE chats.id = merges.chat_id AND merges.chat_id <> merges.target_id`},
+			{"refresh last messages", `
+				UPDATE chats SET last_message_id = latest.id, last_message_text = latest.text
+				FROM (
+					SELECT DISTINCT ON (messages.chat_id) messages.chat_id, messages.id, messages.text
+					FROM messages
+					JOIN direct_chat_merges merges ON merges.target_id = messages.chat_id
+					WHERE messages.thread_root_id IS NULL
+					ORDER BY messages.chat_id, messages.id DESC
+				) latest
+				WHERE chats.id = latest.chat_id`},
+			{"set direct keys", `
+				UPDATE chats SET direct_key = merges.direct_key
+				FROM direct_chat_merges merges
+				WHERE chats.id = merges.target_id AND merges.chat_id = merges.target_id`},
+		}
+
+		for _, step := range steps {
+			if err := tx.Exec(step.query).Error; err != nil {
+				return fmt.Errorf("failed to %s: %w", step.name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// dropLegacyReadFlags replaces the never-updated messages.is_read flag and the
+// stored chats.unread_count with per-member read cursors. Existing members start
+// with everything up to the current last message marked as read.
+func dropLegacyReadFlags(db *gorm.DB) error {
+	if !db.Migrator().HasColumn("messages", "is_read") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`
+			UPDATE chat_members
+			SET last_read_message_id = chats.last_message_id, last_read_at = NOW()
+			FROM chats
+			WHERE chats.id = chat_members.chat_id
+				AND chats.last_message_id IS NOT NULL
+				AND chat_members.last_read_message_id IS NULL
+		`).Error; err != nil {
+			return fmt.Errorf("failed to backfill read cursors: %w", err)
+		}
+
+		if err := tx.Exec("ALTER TABLE messages DROP COLUMN is_read").Error; err != nil {
+			return fmt.Errorf("failed to drop messages.is_read: %w", err)
+		}
+
+		if err := tx.Exec("ALTER TABLE chats DROP COLUMN IF EXISTS unread_count").Error; err != nil {
+			return fmt.Errorf("failed to drop chats.unread_count: %w", err)
+		}
+
+		return nil
+	})
 }
@@ -0,0 +1,180 @@
+package attachment
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"gin-real-time-talk/config"
+	"gin-real-time-talk/internal/entity/interfaces"
+
+	"github.com/gin-gonic/gin"
+)
+
+const multipartOverhead = 1 << 20
+
+type AttachmentController struct {
+	attachmentUsecase interfaces.AttachmentUsecase
+}
+
+func NewAttachmentController(attachmentUsecase interfaces.AttachmentUsecase) *AttachmentController {
+	return &AttachmentController{
+		attachmentUsecase: attachmentUsecase,
+	}
+}
+
+// UploadAttachment godoc
+// @Summary Upload attachment
+// @Description Uploads a file that can then be attached to a message via attachmentIds. The MIME type is detected from the file contents
+// @Tags attachments
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param file formData file true "File to upload"
+// @Success 201 {object} map[string]interface{} "Uploaded attachment"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Failure 413 {object} map[string]string "File is too large"
+// @Router /attachments [post]
+func (ac *AttachmentController) UploadAttachment(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.Env.Upload.MaxSize+multipartOverhead)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"success": false, "error": "file is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "file is required"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	attachment, err := ac.attachmentUsecase.Upload(userID, fileHeader.Filename, fileHeader.Size, file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    attachment,
+	})
+}
+
+// DownloadAttachment godoc
+// @Summary Download attachment
+// @Description Streams the attachment file. Available to the uploader and to members of the chat the attachment was sent to
+// @Tags attachments
+// @Produce octet-stream
+// @Security BearerAuth
+// @Param id path int true "Attachment ID"
+// @Success 200 {file} file "Attachment contents"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Failure 404 {object} map[string]string "Attachment not found"
+// @Router /attachments/{id} [get]
+func (ac *AttachmentController) DownloadAttachment(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	attachmentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || attachmentID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "invalid attachment ID"})
+		return
+	}
+
+	attachment, file, err := ac.attachmentUsecase.Open(uint(attachmentID), userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Type", attachment.MimeType)
+	c.Header("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Status(http.StatusOK)
+
+	_, _ = io.Copy(c.Writer, file)
+}
+
+// GetAttachmentThumbnail godoc
+// @Summary Get attachment thumbnail
+// @Description Streams a JPEG thumbnail of an image attachment. Thumbnails are generated in the background, so they may be unavailable right after upload
+// @Tags attachments
+// @Produce jpeg
+// @Security BearerAuth
+// @Param id path int true "Attachment ID"
+// @Param size path int true "Thumbnail size (64, 320 or 1280)"
+// @Success 200 {file} file "Thumbnail contents"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Failure 404 {object} map[string]string "Thumbnail not found"
+// @Router /attachments/{id}/thumbnails/{size} [get]
+func (ac *AttachmentController) GetAttachmentThumbnail(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	attachmentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || attachmentID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "invalid attachment ID"})
+		return
+	}
+
+	size, err := strconv.Atoi(c.Param("size"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "invalid thumbnail size"})
+		return
+	}
+
+	file, err := ac.attachmentUsecase.OpenThumbnail(uint(attachmentID), userID, size)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Type", "image/jpeg")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Status(http.StatusOK)
+
+	_, _ = io.Copy(c.Writer, file)
+}
+
+func currentUserID(c *gin.Context) (uint, bool) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "user not found"})
+		return 0, false
+	}
+
+	userIDUint, ok := userID.(uint)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "invalid user ID"})
+		return 0, false
+	}
+
+	return userIDUint, true
+}
@@ -0,0 +1,29 @@
+package attachment
+
+import (
+	"gin-real-time-talk/internal/entity/interfaces"
+	"gin-real-time-talk/internal/usecase/attachment_usecase"
+	"gin-real-time-talk/internal/usecase/repository"
+	"gin-real-time-talk/pkg/middleware"
+	"gin-real-time-talk/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func SetupAttachmentRoutes(api *gin.RouterGroup, db *gorm.DB, authUsecase interfaces.AuthUsecase, fileStorage storage.Storage, thumbnailWorker *attachment_usecase.ThumbnailWorker) {
+	attachmentRepo := repository.NewAttachmentRepository(db)
+	messageRepo := repository.NewMessageRepository(db)
+	chatRepo := repository.NewChatRepository(db)
+
+	attachmentUsecase := attachment_usecase.NewAttachmentUsecase(attachmentRepo, messageRepo, chatRepo, fileStorage, thumbnailWorker)
+	attachmentController := NewAttachmentController(attachmentUsecase)
+
+	attachments := api.Group("/attachments")
+	attachments.Use(middleware.AuthMiddleware(authUsecase))
+	{
+		attachments.POST("", attachmentController.UploadAttachment)
+		attachments.GET("/:id", attachmentController.DownloadAttachment)
+		attachments.GET("/:id/thumbnails/:size", attachmentController.GetAttachmentThumbnail)
+	}
+}
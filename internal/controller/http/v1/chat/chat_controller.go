@@ -1,10 +1,15 @@
 package chat
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
+	"gin-real-time-talk/config"
+	"gin-real-time-talk/internal/entity"
 	"gin-real-time-talk/internal/entity/interfaces"
+	"gin-real-time-talk/pkg/middleware"
 	"gin-real-time-talk/pkg/pagination"
 	"gin-real-time-talk/pkg/websocket"
 
@@ -14,14 +19,37 @@ import (
 
 type ChatController struct {
 	chatUsecase interfaces.ChatUsecase
+	authUsecase interfaces.AuthUsecase
 	hub         *websocket.Hub
+	typing      *typingTracker
+	connLimiter *websocket.ConnectionLimiter
 }
 
-func NewChatController(chatUsecase interfaces.ChatUsecase, hub *websocket.Hub) *ChatController {
-	return &ChatController{
+func NewChatController(chatUsecase interfaces.ChatUsecase, authUsecase interfaces.AuthUsecase, hub *websocket.Hub) *ChatController {
+	cc := &ChatController{
 		chatUsecase: chatUsecase,
+		authUsecase: authUsecase,
 		hub:         hub,
+		typing:      newTypingTracker(),
 	}
+
+	if hub != nil {
+		cc.connLimiter = websocket.NewConnectionLimiter(
+			hub,
+			int(config.Env.WebSocket.MaxConnsPerUser),
+			int(config.Env.WebSocket.MaxConnsPerIP),
+		)
+		hub.OnDelivered(cc.handleDelivered)
+		hub.OnPresence(cc.handlePresence)
+		hub.CheckUser(authUsecase.CheckUserActive)
+		hub.Observe("typing", cc.observeTyping)
+		hub.HandleCommand(websocket.CommandTypingStart, cc.handleTypingStart)
+		hub.HandleCommand(websocket.CommandTypingStop, cc.handleTypingStop)
+		hub.HandleCommand(websocket.CommandSendMessage, cc.handleSendMessage)
+		hub.HandleCommand(websocket.CommandReauth, cc.handleReauth)
+	}
+
+	return cc
 }
 
 // GetUserChats godoc
@@ -39,15 +67,8 @@ func NewChatController(chatUsecase interfaces.ChatUsecase, hub *websocket.Hub) *
 // @Failure 401 {object} map[string]string "Unauthorized"
 // @Router /chats [get]
 func (cc *ChatController) GetUserChats(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "user not found"})
-		return
-	}
-
-	userIDUint, ok := userID.(uint)
+	userID, ok := currentUserID(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "invalid user ID"})
 		return
 	}
 
@@ -55,12 +76,15 @@ func (cc *ChatController) GetUserChats(c *gin.Context) {
 	nextToken := c.Query("nextToken")
 	search := c.Query("search")
 
-	chats, token, err := cc.chatUsecase.GetUserChats(userIDUint, limit, nextToken, search)
+	chats, token, err := cc.chatUsecase.GetUserChats(userID, limit, nextToken, search)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
 		return
 	}
 
+	cc.markAllDelivered(userID)
+	cc.attachOnlineStatus(chats)
+
 	response := gin.H{
 		"success": true,
 		"data":    pagination.BuildPaginatedResponse(chats, token),
@@ -84,34 +108,33 @@ func (cc *ChatController) GetUserChats(c *gin.Context) {
 // @Failure 401 {object} map[string]string "Unauthorized"
 // @Router /chats/{id}/messages [get]
 func (cc *ChatController) GetChatMessages(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "user not found"})
-		return
-	}
-
-	userIDUint, ok := userID.(uint)
+	userID, ok := currentUserID(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "invalid user ID"})
 		return
 	}
 
-	chatIDStr := c.Param("id")
-	chatID, err := strconv.ParseUint(chatIDStr, 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "invalid chat ID"})
+	chatID, ok := uintParam(c, "id", "invalid chat ID")
+	if !ok {
 		return
 	}
 
 	limit := pagination.ParseLimit(c.DefaultQuery("limit", strconv.Itoa(pagination.DefaultLimit)))
 	nextToken := c.Query("nextToken")
 
-	messages, token, err := cc.chatUsecase.GetChatMessages(uint(chatID), userIDUint, limit, nextToken)
+	messages, token, err := cc.chatUsecase.GetChatMessages(chatID, userID, limit, nextToken)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
 		return
 	}
 
+	var latestMessageID uint
+	for _, message := range messages {
+		latestMessageID = max(latestMessageID, message.ID)
+	}
+	if latestMessageID != 0 {
+		cc.handleDelivered(userID, chatID, latestMessageID)
+	}
+
 	response := gin.H{
 		"success": true,
 		"data":    pagination.BuildPaginatedResponse(messages, token),
@@ -121,32 +144,59 @@ func (cc *ChatController) GetChatMessages(c *gin.Context) {
 }
 
 type CreateMessageRequest struct {
-	RecipientID uint   `json:"recipientId" binding:"required"`
-	Text        string `json:"text" binding:"required,min=1"`
+	ChatID          uint   `json:"chatId"`
+	RecipientID     uint   `json:"recipientId"`
+	Text            string `json:"text"`
+	ReplyToID       *uint  `json:"replyToId"`
+	ThreadRootID    *uint  `json:"threadRootId"`
+	AttachmentIDs   []uint `json:"attachmentIds" binding:"max=10"`
+	ClientMessageID string `json:"clientMessageId" binding:"max=64"`
+}
+
+func (r *CreateMessageRequest) validate(senderID uint) error {
+	if r.Text == "" && len(r.AttachmentIDs) == 0 {
+		return errors.New("text or attachments are required")
+	}
+
+	if (r.ChatID == 0) == (r.RecipientID == 0) {
+		return errors.New("exactly one of chatId or recipientId is required")
+	}
+
+	if senderID == r.RecipientID {
+		return errors.New("cannot send message to yourself")
+	}
+
+	return nil
+}
+
+func (r *CreateMessageRequest) input() interfaces.CreateMessageInput {
+	return interfaces.CreateMessageInput{
+		ChatID:          r.ChatID,
+		RecipientID:     r.RecipientID,
+		Text:            r.Text,
+		ReplyToID:       r.ReplyToID,
+		ThreadRootID:    r.ThreadRootID,
+		AttachmentIDs:   r.AttachmentIDs,
+		ClientMessageID: r.ClientMessageID,
+	}
 }
 
 // CreateMessage godoc
 // @Summary Create message
-// @Description Creates a new message in a chat. If chat doesn't exist between users, creates a new chat
+// @Description Creates a new message in a chat identified by chatId. When recipientId is given instead, the direct chat between users is used or created. Text may be empty when attachmentIds are given. Retries carrying the same Idempotency-Key header or clientMessageId return the originally created message
 // @Tags chats
 // @Accept json
 // @Produce json
 // @Security BearerAuth
+// @Param Idempotency-Key header string false "Client-generated key used to deduplicate retries"
 // @Param request body CreateMessageRequest true "Message creation request"
 // @Success 200 {object} map[string]interface{} "Created message"
 // @Failure 400 {object} map[string]string "Bad request"
 // @Failure 401 {object} map[string]string "Unauthorized"
 // @Router /chat/message [post]
 func (cc *ChatController) CreateMessage(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "user not found"})
-		return
-	}
-
-	senderID, ok := userID.(uint)
+	senderID, ok := currentUserID(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "invalid user ID"})
 		return
 	}
 
@@ -156,24 +206,30 @@ func (cc *ChatController) CreateMessage(c *gin.Context) {
 		return
 	}
 
-	if senderID == req.RecipientID {
-		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "cannot send message to yourself"})
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		if req.ClientMessageID != "" && req.ClientMessageID != key {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Idempotency-Key header does not match clientMessageId"})
+			return
+		}
+		req.ClientMessageID = key
+	}
+
+	if err := req.validate(senderID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
 		return
 	}
 
-	message, err := cc.chatUsecase.CreateMessage(senderID, req.RecipientID, req.Text)
+	message, replayed, err := cc.chatUsecase.CreateMessage(senderID, req.input())
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
 		return
 	}
 
-	if cc.hub != nil {
-		wsMessage := &websocket.Message{
-			Type:    "new_message",
-			Message: message,
-		}
-		cc.hub.BroadcastToUser(req.RecipientID, wsMessage)
-		cc.hub.BroadcastToUser(senderID, wsMessage)
+	if replayed {
+		c.Header("Idempotent-Replayed", "true")
+	} else {
+		cc.stopTyping(message.ChatID, senderID)
+		cc.broadcastNewMessage(message)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -182,33 +238,162 @@ func (cc *ChatController) CreateMessage(c *gin.Context) {
 	})
 }
 
-func (cc *ChatController) HandleWebSocket(c *gin.Context) {
+func (cc *ChatController) broadcastNewMessage(message *entity.Message) {
+	if message.IsThreadReply() {
+		cc.broadcastToChat(message.ChatID, &websocket.Message{
+			Type:    "thread_reply",
+			Data:    gin.H{"threadRootId": *message.ThreadRootID},
+			Message: message,
+		})
+		return
+	}
+
+	cc.broadcastToChat(message.ChatID, &websocket.Message{
+		Type:    "new_message",
+		Message: message,
+	})
+}
+
+func (cc *ChatController) broadcastToChat(chatID uint, wsMessage *websocket.Message) {
+	if cc.hub == nil {
+		return
+	}
+
+	memberIDs, err := cc.chatUsecase.GetChatMemberIDs(chatID)
+	if err != nil {
+		return
+	}
+
+	cc.hub.BroadcastToUsers(memberIDs, wsMessage)
+}
+
+func currentUserID(c *gin.Context) (uint, bool) {
 	userID, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "user not found"})
-		return
+		return 0, false
 	}
 
 	userIDUint, ok := userID.(uint)
 	if !ok {
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "invalid user ID"})
+		return 0, false
+	}
+
+	return userIDUint, true
+}
+
+func uintParam(c *gin.Context, name string, errorMessage string) (uint, bool) {
+	value, err := strconv.ParseUint(c.Param(name), 10, 32)
+	if err != nil || value == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": errorMessage})
+		return 0, false
+	}
+
+	return uint(value), true
+}
+
+func sinceParam(c *gin.Context, value string) (uint64, bool) {
+	if value == "" {
+		return 0, true
+	}
+
+	since, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "invalid since"})
+		return 0, false
+	}
+
+	return since, true
+}
+
+// HandleWebSocket upgrades the connection after authenticating it with the
+// access token from the cookie, the Authorization header or the
+// Sec-WebSocket-Protocol header. Clients that can pass none of them send an
+// auth frame first instead. Clients pick the JSON or msgpack encoding by
+// offering its subprotocol. Upgrades from origins outside the CORS allowlist
+// and beyond the per-user or per-address connection limits are rejected.
+func (cc *ChatController) HandleWebSocket(c *gin.Context) {
+	if !middleware.OriginAllowed(c.Request) {
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "origin not allowed"})
+		return
+	}
+
+	since, ok := sinceParam(c, c.Query("since"))
+	if !ok {
+		return
+	}
+
+	token := middleware.AccessToken(c)
+	if token == "" {
+		token = websocket.ProtocolAccessToken(c.Request)
+	}
+
+	var user *entity.User
+	var expiresAt time.Time
+	if token != "" {
+		var err error
+		user, expiresAt, err = cc.authUsecase.ValidateAccessTokenExpiry(token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "invalid or expired token"})
+			return
+		}
+	}
+
+	ip := c.ClientIP()
+	if err := cc.connLimiter.AcquireIP(ip); err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"success": false, "error": err.Error()})
 		return
 	}
 
+	if user != nil {
+		if err := cc.connLimiter.AcquireUser(user.ID); err != nil {
+			cc.connLimiter.ReleaseIP(ip)
+			c.JSON(http.StatusTooManyRequests, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+	}
+
 	upgrader := ws.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+		Subprotocols:      websocket.Protocols,
+		CheckOrigin:       middleware.OriginAllowed,
+		EnableCompression: true,
 	}
 
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
+		cc.connLimiter.ReleaseIP(ip)
+		if user != nil {
+			cc.connLimiter.ReleaseUser(user.ID)
+		}
 		return
 	}
 
-	client := websocket.NewClient(cc.hub, conn, userIDUint)
+	if user == nil {
+		token, err := websocket.ReadAuthToken(conn)
+		if err == nil {
+			user, expiresAt, err = cc.authUsecase.ValidateAccessTokenExpiry(token)
+		}
+		if err != nil {
+			cc.connLimiter.ReleaseIP(ip)
+			websocket.CloseWithCode(conn, websocket.CloseAuthenticationFailed, "authentication failed")
+			return
+		}
+
+		if err := cc.connLimiter.AcquireUser(user.ID); err != nil {
+			cc.connLimiter.ReleaseIP(ip)
+			websocket.CloseWithCode(conn, websocket.CloseTooManyConnections, err.Error())
+			return
+		}
+	}
+
+	client := websocket.NewClient(cc.hub, conn, user.ID, since, expiresAt)
 	cc.hub.Register(client)
 
 	go client.WritePump()
-	go client.ReadPump()
+	go func() {
+		client.ReadPump()
+		cc.connLimiter.ReleaseUser(user.ID)
+		cc.connLimiter.ReleaseIP(ip)
+	}()
 }
@@ -0,0 +1,253 @@
+package chat
+
+import (
+	"net/http"
+
+	"gin-real-time-talk/internal/entity"
+	"gin-real-time-talk/pkg/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateGroupChatRequest struct {
+	Title     string  `json:"title" binding:"required"`
+	Photo     *string `json:"photo"`
+	MemberIDs []uint  `json:"memberIds"`
+}
+
+type AddChatMembersRequest struct {
+	MemberIDs []uint `json:"memberIds" binding:"required,min=1"`
+}
+
+type UpdateChatMemberRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=owner admin member"`
+}
+
+// CreateGroupChat godoc
+// @Summary Create group chat
+// @Description Creates a group chat owned by the authenticated user with the given members
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body CreateGroupChatRequest true "Group chat creation request"
+// @Success 201 {object} map[string]interface{} "Created group chat"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Router /chats [post]
+func (cc *ChatController) CreateGroupChat(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req CreateGroupChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	chat, err := cc.chatUsecase.CreateGroupChat(userID, req.Title, req.Photo, req.MemberIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	cc.broadcastToChat(chat.ID, &websocket.Message{
+		Type: "chat_created",
+		Data: chat,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    chat,
+	})
+}
+
+// GetChatMembers godoc
+// @Summary Get chat members
+// @Description Returns members of a chat with their roles
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Chat ID"
+// @Success 200 {object} map[string]interface{} "List of chat members"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Router /chats/{id}/members [get]
+func (cc *ChatController) GetChatMembers(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	chatID, ok := uintParam(c, "id", "invalid chat ID")
+	if !ok {
+		return
+	}
+
+	members, err := cc.chatUsecase.GetChatMembers(chatID, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    members,
+	})
+}
+
+// AddChatMembers godoc
+// @Summary Add chat members
+// @Description Adds users to a group chat. Available to the owner and admins
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Chat ID"
+// @Param request body AddChatMembersRequest true "Users to add"
+// @Success 200 {object} map[string]interface{} "Updated list of chat members"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Router /chats/{id}/members [post]
+func (cc *ChatController) AddChatMembers(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	chatID, ok := uintParam(c, "id", "invalid chat ID")
+	if !ok {
+		return
+	}
+
+	var req AddChatMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	members, err := cc.chatUsecase.AddChatMembers(chatID, userID, req.MemberIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	cc.broadcastMembersUpdated(chatID, members)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    members,
+	})
+}
+
+// RemoveChatMember godoc
+// @Summary Remove chat member
+// @Description Removes a member from a group chat. Passing your own user ID leaves the chat; when the last member leaves, the chat is deleted
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Chat ID"
+// @Param userId path int true "User ID"
+// @Success 200 {object} map[string]interface{} "Remaining chat members"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Router /chats/{id}/members/{userId} [delete]
+func (cc *ChatController) RemoveChatMember(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	chatID, ok := uintParam(c, "id", "invalid chat ID")
+	if !ok {
+		return
+	}
+
+	memberID, ok := uintParam(c, "userId", "invalid user ID")
+	if !ok {
+		return
+	}
+
+	members, err := cc.chatUsecase.RemoveChatMember(chatID, userID, memberID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	cc.broadcastMembersUpdated(chatID, members)
+	if cc.hub != nil {
+		cc.hub.BroadcastToUser(memberID, &websocket.Message{
+			Type: "chat_removed",
+			Data: gin.H{"chatId": chatID},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    members,
+	})
+}
+
+// UpdateChatMemberRole godoc
+// @Summary Update chat member role
+// @Description Promotes or demotes a group member. Setting the owner role transfers ownership and makes the current owner an admin
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Chat ID"
+// @Param userId path int true "User ID"
+// @Param request body UpdateChatMemberRoleRequest true "New role"
+// @Success 200 {object} map[string]interface{} "Updated list of chat members"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Router /chats/{id}/members/{userId} [patch]
+func (cc *ChatController) UpdateChatMemberRole(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	chatID, ok := uintParam(c, "id", "invalid chat ID")
+	if !ok {
+		return
+	}
+
+	memberID, ok := uintParam(c, "userId", "invalid user ID")
+	if !ok {
+		return
+	}
+
+	var req UpdateChatMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	members, err := cc.chatUsecase.UpdateChatMemberRole(chatID, userID, memberID, req.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	cc.broadcastMembersUpdated(chatID, members)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    members,
+	})
+}
+
+func (cc *ChatController) broadcastMembersUpdated(chatID uint, members []entity.ChatMember) {
+	cc.broadcastToChat(chatID, &websocket.Message{
+		Type: "chat_members_updated",
+		Data: gin.H{
+			"chatId":  chatID,
+			"members": members,
+		},
+	})
+}
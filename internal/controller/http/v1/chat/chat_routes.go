@@ -5,29 +5,54 @@ import (
 	"gin-real-time-talk/internal/usecase/chat_usecase"
 	"gin-real-time-talk/internal/usecase/repository"
 	"gin-real-time-talk/pkg/middleware"
+	"gin-real-time-talk/pkg/storage"
 	"gin-real-time-talk/pkg/websocket"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func SetupChatRoutes(api *gin.RouterGroup, db *gorm.DB, authUsecase interfaces.AuthUsecase, hub *websocket.Hub) {
+func SetupChatRoutes(api *gin.RouterGroup, db *gorm.DB, authUsecase interfaces.AuthUsecase, hub *websocket.Hub, fileStorage storage.Storage) {
 	chatRepo := repository.NewChatRepository(db)
 	messageRepo := repository.NewMessageRepository(db)
-	chatUsecase := chat_usecase.NewChatUsecase(chatRepo, messageRepo)
-	chatController := NewChatController(chatUsecase, hub)
+	userRepo := repository.NewUserRepository(db)
+	attachmentRepo := repository.NewAttachmentRepository(db)
+	chatUsecase := chat_usecase.NewChatUsecase(chatRepo, messageRepo, userRepo, attachmentRepo, fileStorage)
+	chatController := NewChatController(chatUsecase, authUsecase, hub)
 
 	chats := api.Group("/chats")
 	chats.Use(middleware.AuthMiddleware(authUsecase))
 	{
 		chats.GET("", chatController.GetUserChats)
+		chats.POST("", chatController.CreateGroupChat)
 		chats.GET("/:id/messages", chatController.GetChatMessages)
+		chats.PATCH("/:id/messages/:messageId", chatController.EditMessage)
+		chats.DELETE("/:id/messages/:messageId", chatController.DeleteMessage)
+		chats.GET("/:id/messages/:messageId/thread", chatController.GetThreadMessages)
+		chats.POST("/:id/messages/:messageId/reactions", chatController.AddReaction)
+		chats.DELETE("/:id/messages/:messageId/reactions", chatController.RemoveReaction)
+		chats.POST("/:id/read", chatController.MarkChatRead)
+		chats.GET("/:id/pins", chatController.GetPinnedMessages)
+		chats.POST("/:id/pins", chatController.PinMessage)
+		chats.DELETE("/:id/pins/:messageId", chatController.UnpinMessage)
+		chats.GET("/:id/members", chatController.GetChatMembers)
+		chats.POST("/:id/members", chatController.AddChatMembers)
+		chats.PATCH("/:id/members/:userId", chatController.UpdateChatMemberRole)
+		chats.DELETE("/:id/members/:userId", chatController.RemoveChatMember)
+	}
+
+	messages := api.Group("/messages")
+	messages.Use(middleware.AuthMiddleware(authUsecase))
+	{
+		messages.POST("/forward", chatController.ForwardMessages)
 	}
 
 	chat := api.Group("/chat")
 	chat.Use(middleware.AuthMiddleware(authUsecase))
 	{
 		chat.POST("/message", chatController.CreateMessage)
-		chat.GET("/ws", chatController.HandleWebSocket)
 	}
+
+	api.GET("/chat/ws", chatController.HandleWebSocket)
+	api.GET("/chat/events", chatController.StreamEvents)
 }
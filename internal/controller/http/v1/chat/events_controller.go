@@ -0,0 +1,63 @@
+package chat
+
+import (
+	"net/http"
+
+	"gin-real-time-talk/pkg/middleware"
+	"gin-real-time-talk/pkg/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StreamEvents godoc
+// @Summary Stream realtime events
+// @Description Streams the events delivered over the websocket as Server-Sent Events, for networks where websockets are blocked. Each event with a sequence number carries it as its id; reconnecting with Last-Event-ID (or since) replays missed events or sends resync_required. The stream ends with a close event when the access token expires
+// @Tags chats
+// @Produce text/event-stream
+// @Security BearerAuth
+// @Param since query int false "Sequence number to resume after"
+// @Param Last-Event-ID header int false "Sequence number to resume after, sent by EventSource on reconnect"
+// @Success 200 {string} string "Event stream"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Failure 429 {object} map[string]string "Too many connections"
+// @Router /chat/events [get]
+func (cc *ChatController) StreamEvents(c *gin.Context) {
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("since")
+	}
+
+	since, ok := sinceParam(c, lastEventID)
+	if !ok {
+		return
+	}
+
+	token := middleware.AccessToken(c)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "access token required"})
+		return
+	}
+
+	user, expiresAt, err := cc.authUsecase.ValidateAccessTokenExpiry(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "invalid or expired token"})
+		return
+	}
+
+	ip := c.ClientIP()
+	if err := cc.connLimiter.AcquireIP(ip); err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	defer cc.connLimiter.ReleaseIP(ip)
+
+	if err := cc.connLimiter.AcquireUser(user.ID); err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	defer cc.connLimiter.ReleaseUser(user.ID)
+
+	client := websocket.NewSSEClient(cc.hub, user.ID, since, expiresAt)
+	client.ServeSSE(c.Writer, c.Request)
+}
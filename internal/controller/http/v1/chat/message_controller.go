@@ -0,0 +1,220 @@
+package chat
+
+import (
+	"net/http"
+	"strconv"
+
+	"gin-real-time-talk/internal/entity"
+	"gin-real-time-talk/pkg/pagination"
+	"gin-real-time-talk/pkg/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EditMessageRequest struct {
+	Text string `json:"text" binding:"required,min=1"`
+}
+
+// EditMessage godoc
+// @Summary Edit message
+// @Description Changes the text of a message. Only the author can edit, and only within the configured edit window
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Chat ID"
+// @Param messageId path int true "Message ID"
+// @Param request body EditMessageRequest true "New message text"
+// @Success 200 {object} map[string]interface{} "Edited message"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Router /chats/{id}/messages/{messageId} [patch]
+func (cc *ChatController) EditMessage(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	chatID, ok := uintParam(c, "id", "invalid chat ID")
+	if !ok {
+		return
+	}
+
+	messageID, ok := uintParam(c, "messageId", "invalid message ID")
+	if !ok {
+		return
+	}
+
+	var req EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	message, err := cc.chatUsecase.EditMessage(chatID, messageID, userID, req.Text)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	cc.broadcastToChat(chatID, &websocket.Message{
+		Type:    "message_edited",
+		Message: message,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    message,
+	})
+}
+
+// DeleteMessage godoc
+// @Summary Delete message
+// @Description Deletes a message. Scope "me" hides it for the current user only; scope "everyone" replaces it with a tombstone for all members and is available to the author and group admins
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Chat ID"
+// @Param messageId path int true "Message ID"
+// @Param scope query string false "Delete scope: me or everyone (default: me)"
+// @Success 200 {object} map[string]interface{} "Message deleted"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Router /chats/{id}/messages/{messageId} [delete]
+func (cc *ChatController) DeleteMessage(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	chatID, ok := uintParam(c, "id", "invalid chat ID")
+	if !ok {
+		return
+	}
+
+	messageID, ok := uintParam(c, "messageId", "invalid message ID")
+	if !ok {
+		return
+	}
+
+	scope := c.DefaultQuery("scope", entity.MessageDeleteScopeMe)
+
+	message, err := cc.chatUsecase.DeleteMessage(chatID, messageID, userID, scope)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	wsMessage := &websocket.Message{
+		Type: "message_deleted",
+		Data: gin.H{
+			"chatId":    chatID,
+			"messageId": messageID,
+			"scope":     scope,
+		},
+	}
+
+	if scope == entity.MessageDeleteScopeEveryone {
+		cc.broadcastToChat(chatID, wsMessage)
+	} else if cc.hub != nil {
+		cc.hub.BroadcastToUser(userID, wsMessage)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    message,
+	})
+}
+
+// GetThreadMessages godoc
+// @Summary Get thread messages
+// @Description Returns paginated replies in the thread started by a message
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Chat ID"
+// @Param messageId path int true "Thread root message ID"
+// @Param limit query int false "Number of items per page (default: 20, max: 100)"
+// @Param nextToken query string false "Token for pagination (cursor-based)"
+// @Success 200 {object} map[string]interface{} "List of thread replies with pagination info"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Router /chats/{id}/messages/{messageId}/thread [get]
+func (cc *ChatController) GetThreadMessages(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	chatID, ok := uintParam(c, "id", "invalid chat ID")
+	if !ok {
+		return
+	}
+
+	messageID, ok := uintParam(c, "messageId", "invalid message ID")
+	if !ok {
+		return
+	}
+
+	limit := pagination.ParseLimit(c.DefaultQuery("limit", strconv.Itoa(pagination.DefaultLimit)))
+	nextToken := c.Query("nextToken")
+
+	messages, token, err := cc.chatUsecase.GetThreadMessages(chatID, messageID, userID, limit, nextToken)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    pagination.BuildPaginatedResponse(messages, token),
+	})
+}
+
+type ForwardMessagesRequest struct {
+	MessageIDs []uint `json:"messageIds" binding:"required,min=1"`
+	ChatIDs    []uint `json:"chatIds"`
+	UserIDs    []uint `json:"userIds"`
+}
+
+// ForwardMessages godoc
+// @Summary Forward messages
+// @Description Copies messages into one or more chats. Targets can be existing chats or users, in which case the direct chat with them is used or created
+// @Tags messages
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body ForwardMessagesRequest true "Messages and forward targets"
+// @Success 200 {object} map[string]interface{} "Forwarded messages"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Router /messages/forward [post]
+func (cc *ChatController) ForwardMessages(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req ForwardMessagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	messages, err := cc.chatUsecase.ForwardMessages(userID, req.MessageIDs, req.ChatIDs, req.UserIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	for i := range messages {
+		cc.broadcastNewMessage(&messages[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    messages,
+	})
+}
@@ -0,0 +1,149 @@
+package chat
+
+import (
+	"net/http"
+
+	"gin-real-time-talk/internal/entity"
+	"gin-real-time-talk/pkg/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PinMessageRequest struct {
+	MessageID uint `json:"messageId" binding:"required"`
+}
+
+// GetPinnedMessages godoc
+// @Summary Get pinned messages
+// @Description Returns pinned messages of a chat in the order they were pinned
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Chat ID"
+// @Success 200 {object} map[string]interface{} "List of pinned messages"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Router /chats/{id}/pins [get]
+func (cc *ChatController) GetPinnedMessages(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	chatID, ok := uintParam(c, "id", "invalid chat ID")
+	if !ok {
+		return
+	}
+
+	pins, err := cc.chatUsecase.GetPinnedMessages(chatID, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    pins,
+	})
+}
+
+// PinMessage godoc
+// @Summary Pin message
+// @Description Pins a message in a chat and posts a system message about it. In group chats only the owner and admins can pin
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Chat ID"
+// @Param request body PinMessageRequest true "Message to pin"
+// @Success 200 {object} map[string]interface{} "Updated list of pinned messages"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Router /chats/{id}/pins [post]
+func (cc *ChatController) PinMessage(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	chatID, ok := uintParam(c, "id", "invalid chat ID")
+	if !ok {
+		return
+	}
+
+	var req PinMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	systemMessage, err := cc.chatUsecase.PinMessage(chatID, req.MessageID, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	cc.respondPinsUpdated(c, chatID, userID, systemMessage)
+}
+
+// UnpinMessage godoc
+// @Summary Unpin message
+// @Description Unpins a message in a chat and posts a system message about it. In group chats only the owner and admins can unpin
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Chat ID"
+// @Param messageId path int true "Message ID"
+// @Success 200 {object} map[string]interface{} "Updated list of pinned messages"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Router /chats/{id}/pins/{messageId} [delete]
+func (cc *ChatController) UnpinMessage(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	chatID, ok := uintParam(c, "id", "invalid chat ID")
+	if !ok {
+		return
+	}
+
+	messageID, ok := uintParam(c, "messageId", "invalid message ID")
+	if !ok {
+		return
+	}
+
+	systemMessage, err := cc.chatUsecase.UnpinMessage(chatID, messageID, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	cc.respondPinsUpdated(c, chatID, userID, systemMessage)
+}
+
+func (cc *ChatController) respondPinsUpdated(c *gin.Context, chatID uint, userID uint, systemMessage *entity.Message) {
+	cc.broadcastNewMessage(systemMessage)
+
+	pins, err := cc.chatUsecase.GetPinnedMessages(chatID, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	cc.broadcastToChat(chatID, &websocket.Message{
+		Type: "pins_updated",
+		Data: gin.H{
+			"chatId": chatID,
+			"pins":   pins,
+		},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    pins,
+	})
+}
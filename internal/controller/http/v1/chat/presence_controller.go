@@ -0,0 +1,50 @@
+package chat
+
+import (
+	"fmt"
+
+	"gin-real-time-talk/internal/entity"
+	"gin-real-time-talk/pkg/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (cc *ChatController) handlePresence(userID uint, online bool) {
+	lastSeenAt, contactIDs, err := cc.chatUsecase.UpdatePresence(userID, online)
+	if err != nil {
+		return
+	}
+
+	cc.hub.BroadcastToUsers(contactIDs, &websocket.Message{
+		Type:        "presence",
+		CoalesceKey: fmt.Sprintf("presence:%d", userID),
+		Data: gin.H{
+			"userId":     userID,
+			"online":     online,
+			"lastSeenAt": lastSeenAt,
+		},
+	})
+}
+
+func (cc *ChatController) attachOnlineStatus(chats []entity.Chat) {
+	if cc.hub == nil {
+		return
+	}
+
+	var userIDs []uint
+	for i := range chats {
+		if chats[i].User != nil {
+			userIDs = append(userIDs, chats[i].User.ID)
+		}
+	}
+	if len(userIDs) == 0 {
+		return
+	}
+
+	online := cc.hub.OnlineUsers(userIDs)
+	for i := range chats {
+		if chats[i].User != nil {
+			chats[i].User.Online = online[chats[i].User.ID]
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package chat
+
+import (
+	"net/http"
+
+	"gin-real-time-talk/internal/entity"
+	"gin-real-time-talk/pkg/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji" binding:"required"`
+}
+
+// AddReaction godoc
+// @Summary Add reaction
+// @Description Adds an emoji reaction of the current user to a message. Each user can add each emoji once
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Chat ID"
+// @Param messageId path int true "Message ID"
+// @Param request body ReactionRequest true "Reaction emoji"
+// @Success 200 {object} map[string]interface{} "Aggregated message reactions"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Router /chats/{id}/messages/{messageId}/reactions [post]
+func (cc *ChatController) AddReaction(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	chatID, ok := uintParam(c, "id", "invalid chat ID")
+	if !ok {
+		return
+	}
+
+	messageID, ok := uintParam(c, "messageId", "invalid message ID")
+	if !ok {
+		return
+	}
+
+	var req ReactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	reactions, err := cc.chatUsecase.AddReaction(chatID, messageID, userID, req.Emoji)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	cc.broadcastReactions(chatID, messageID, reactions)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    entity.SummarizeReactions(reactions, userID),
+	})
+}
+
+// RemoveReaction godoc
+// @Summary Remove reaction
+// @Description Removes an emoji reaction of the current user from a message
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Chat ID"
+// @Param messageId path int true "Message ID"
+// @Param emoji query string true "Reaction emoji"
+// @Success 200 {object} map[string]interface{} "Aggregated message reactions"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Router /chats/{id}/messages/{messageId}/reactions [delete]
+func (cc *ChatController) RemoveReaction(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	chatID, ok := uintParam(c, "id", "invalid chat ID")
+	if !ok {
+		return
+	}
+
+	messageID, ok := uintParam(c, "messageId", "invalid message ID")
+	if !ok {
+		return
+	}
+
+	reactions, err := cc.chatUsecase.RemoveReaction(chatID, messageID, userID, c.Query("emoji"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	cc.broadcastReactions(chatID, messageID, reactions)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    entity.SummarizeReactions(reactions, userID),
+	})
+}
+
+func (cc *ChatController) broadcastReactions(chatID uint, messageID uint, reactions []entity.MessageReaction) {
+	if cc.hub == nil {
+		return
+	}
+
+	memberIDs, err := cc.chatUsecase.GetChatMemberIDs(chatID)
+	if err != nil {
+		return
+	}
+
+	cc.hub.BroadcastToUsers(memberIDs, &websocket.Message{
+		Type: "reaction_updated",
+		Data: gin.H{
+			"chatId":    chatID,
+			"messageId": messageID,
+			"reactions": entity.GroupReactions(reactions),
+		},
+	})
+}
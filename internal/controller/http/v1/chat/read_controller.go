@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"net/http"
+
+	"gin-real-time-talk/internal/entity"
+	"gin-real-time-talk/pkg/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MarkChatReadRequest struct {
+	MessageID uint `json:"messageId"`
+}
+
+// MarkChatRead godoc
+// @Summary Mark chat as read
+// @Description Advances the read cursor of the authenticated user up to messageId, or to the last message of the chat when messageId is omitted. The cursor never moves backwards
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Chat ID"
+// @Param request body MarkChatReadRequest false "Last read message"
+// @Success 200 {object} map[string]interface{} "Updated read cursor"
+// @Failure 400 {object} map[string]string "Bad request"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Router /chats/{id}/read [post]
+func (cc *ChatController) MarkChatRead(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	chatID, ok := uintParam(c, "id", "invalid chat ID")
+	if !ok {
+		return
+	}
+
+	var req MarkChatReadRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+	}
+
+	member, advanced, err := cc.chatUsecase.MarkChatRead(chatID, userID, req.MessageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	readState := gin.H{
+		"chatId":            chatID,
+		"userId":            userID,
+		"lastReadMessageId": member.LastReadMessageID,
+		"readAt":            member.LastReadAt,
+	}
+
+	if advanced {
+		cc.broadcastToChat(chatID, &websocket.Message{
+			Type: "messages_read",
+			Data: readState,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    readState,
+	})
+}
+
+func (cc *ChatController) handleDelivered(userID uint, chatID uint, messageID uint) {
+	member, advanced, err := cc.chatUsecase.MarkDelivered(chatID, userID, messageID)
+	if err != nil || !advanced {
+		return
+	}
+
+	cc.broadcastDelivered(member)
+}
+
+func (cc *ChatController) markAllDelivered(userID uint) {
+	members, err := cc.chatUsecase.MarkAllDelivered(userID)
+	if err != nil {
+		return
+	}
+
+	for i := range members {
+		cc.broadcastDelivered(&members[i])
+	}
+}
+
+func (cc *ChatController) broadcastDelivered(member *entity.ChatMember) {
+	cc.broadcastToChat(member.ChatID, &websocket.Message{
+		Type: "message_delivered",
+		Data: gin.H{
+			"chatId":                 member.ChatID,
+			"userId":                 member.UserID,
+			"lastDeliveredMessageId": member.LastDeliveredMessageID,
+			"deliveredAt":            member.LastDeliveredAt,
+		},
+	})
+}
@@ -0,0 +1,43 @@
+package chat
+
+import (
+	"gin-real-time-talk/pkg/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (cc *ChatController) handleReauth(client *websocket.Client, command *websocket.Command) {
+	var data websocket.AuthCommandData
+	if err := command.DecodeData(&data); err != nil || data.Token == "" {
+		cc.sendCommandError(client, command, websocket.ErrorCodeInvalidPayload, "token is required")
+		return
+	}
+
+	// A user who can no longer log in is disconnected rather than left to run
+	// out their current token.
+	if err := cc.authUsecase.CheckUserActive(client.UserID()); err != nil {
+		cc.hub.CloseClient(client, websocket.CloseAuthenticationFailed, err.Error())
+		return
+	}
+
+	user, expiresAt, err := cc.authUsecase.ValidateAccessTokenExpiry(data.Token)
+	if err != nil {
+		cc.sendCommandError(client, command, websocket.ErrorCodeRejected, err.Error())
+		return
+	}
+
+	if user.ID != client.UserID() {
+		cc.sendCommandError(client, command, websocket.ErrorCodeRejected, "token belongs to another user")
+		return
+	}
+
+	client.Reauthenticate(expiresAt)
+
+	cc.hub.SendToClient(client, &websocket.Message{
+		Type:     "reauthenticated",
+		ClientID: command.ClientID,
+		Data: gin.H{
+			"expiresAt": expiresAt,
+		},
+	})
+}
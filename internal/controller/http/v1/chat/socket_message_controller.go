@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"gin-real-time-talk/pkg/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+func (cc *ChatController) handleSendMessage(client *websocket.Client, command *websocket.Command) {
+	if command.ClientID == "" {
+		cc.sendCommandError(client, command, websocket.ErrorCodeInvalidPayload, "clientId is required")
+		return
+	}
+
+	var req CreateMessageRequest
+	if err := command.DecodeData(&req); err != nil {
+		cc.sendCommandError(client, command, websocket.ErrorCodeInvalidPayload, err.Error())
+		return
+	}
+
+	if req.ClientMessageID == "" {
+		req.ClientMessageID = command.ClientID
+	}
+
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		cc.sendCommandError(client, command, websocket.ErrorCodeValidationFailed, err.Error())
+		return
+	}
+
+	senderID := client.UserID()
+	if err := req.validate(senderID); err != nil {
+		cc.sendCommandError(client, command, websocket.ErrorCodeValidationFailed, err.Error())
+		return
+	}
+
+	message, replayed, err := cc.chatUsecase.CreateMessage(senderID, req.input())
+	if err != nil {
+		cc.sendCommandError(client, command, websocket.ErrorCodeRejected, err.Error())
+		return
+	}
+
+	cc.hub.SendToClient(client, &websocket.Message{
+		Type:     "ack",
+		ClientID: command.ClientID,
+		Data: gin.H{
+			"messageId": message.ID,
+			"chatId":    message.ChatID,
+			"createdAt": message.CreatedAt,
+			"replayed":  replayed,
+		},
+	})
+
+	if !replayed {
+		cc.stopTyping(message.ChatID, senderID)
+		cc.broadcastNewMessage(message)
+	}
+}
+
+func (cc *ChatController) sendCommandError(client *websocket.Client, command *websocket.Command, code string, message string) {
+	cc.hub.SendToClient(client, &websocket.Message{
+		Type:     "error",
+		ClientID: command.ClientID,
+		Data: gin.H{
+			"command": command.Type,
+			"code":    code,
+			"message": message,
+		},
+	})
+}
@@ -0,0 +1,211 @@
+package chat
+
+import (
+	"sync"
+	"time"
+
+	"gin-real-time-talk/pkg/websocket"
+)
+
+const typingTimeout = 6 * time.Second
+
+type typingKey struct {
+	chatID uint
+	userID uint
+}
+
+// typingEntry is a user typing in a chat. Local entries come from typing
+// commands on this node, which also broadcasts their expiry. Remote entries
+// mirror typing events published by other nodes so that a message sent
+// through this node still ends the indicator; they expire silently, since
+// refreshes are not broadcast.
+type typingEntry struct {
+	timer  *time.Timer
+	remote bool
+}
+
+type typingData struct {
+	ChatID   uint `json:"chatId"`
+	UserID   uint `json:"userId"`
+	IsTyping bool `json:"isTyping"`
+}
+
+type typingTracker struct {
+	timers map[typingKey]*typingEntry
+	mu     sync.Mutex
+}
+
+func newTypingTracker() *typingTracker {
+	return &typingTracker{
+		timers: make(map[typingKey]*typingEntry),
+	}
+}
+
+// start returns true when the user was not already typing in the chat. Every
+// call pushes the expiry back, and onExpire runs if no refresh or stop arrives
+// within typingTimeout.
+func (t *typingTracker) start(chatID uint, userID uint, onExpire func()) bool {
+	key := typingKey{chatID: chatID, userID: userID}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	alreadyTyping := false
+	if entry, ok := t.timers[key]; ok && entry.timer.Stop() {
+		if !entry.remote {
+			entry.timer.Reset(typingTimeout)
+			return false
+		}
+		alreadyTyping = true
+	}
+
+	t.track(key, false, onExpire)
+	return !alreadyTyping
+}
+
+// observe mirrors a typing event published by any node.
+func (t *typingTracker) observe(chatID uint, userID uint, isTyping bool) {
+	key := typingKey{chatID: chatID, userID: userID}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.timers[key]
+	if !isTyping {
+		if ok {
+			entry.timer.Stop()
+			delete(t.timers, key)
+		}
+		return
+	}
+
+	if ok && !entry.remote {
+		return
+	}
+	if ok && entry.timer.Stop() {
+		entry.timer.Reset(typingTimeout)
+		return
+	}
+	t.track(key, true, func() {})
+}
+
+func (t *typingTracker) track(key typingKey, remote bool, onExpire func()) {
+	entry := &typingEntry{remote: remote}
+	entry.timer = time.AfterFunc(typingTimeout, func() {
+		t.mu.Lock()
+		if t.timers[key] != entry {
+			t.mu.Unlock()
+			return
+		}
+		delete(t.timers, key)
+		t.mu.Unlock()
+
+		onExpire()
+	})
+	t.timers[key] = entry
+}
+
+func (t *typingTracker) stop(chatID uint, userID uint) bool {
+	key := typingKey{chatID: chatID, userID: userID}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.timers[key]
+	if !ok {
+		return false
+	}
+
+	entry.timer.Stop()
+	delete(t.timers, key)
+
+	return true
+}
+
+func (cc *ChatController) observeTyping(message *websocket.Message) {
+	var data typingData
+	if err := message.DecodeData(&data); err != nil || data.ChatID == 0 {
+		return
+	}
+
+	cc.typing.observe(data.ChatID, data.UserID, data.IsTyping)
+}
+
+func (cc *ChatController) handleTypingStart(client *websocket.Client, command *websocket.Command) {
+	var data websocket.ChatCommandData
+	if err := command.DecodeData(&data); err != nil || data.ChatID == 0 {
+		return
+	}
+
+	chatID, userID := data.ChatID, client.UserID()
+
+	memberIDs, ok := cc.chatMemberIDs(chatID, userID)
+	if !ok {
+		return
+	}
+
+	started := cc.typing.start(chatID, userID, func() {
+		cc.broadcastTyping(chatID, userID, false)
+	})
+	if started {
+		cc.sendTyping(memberIDs, chatID, userID, true)
+	}
+}
+
+func (cc *ChatController) handleTypingStop(client *websocket.Client, command *websocket.Command) {
+	var data websocket.ChatCommandData
+	if err := command.DecodeData(&data); err != nil || data.ChatID == 0 {
+		return
+	}
+
+	cc.stopTyping(data.ChatID, client.UserID())
+}
+
+func (cc *ChatController) stopTyping(chatID uint, userID uint) {
+	if cc.typing.stop(chatID, userID) {
+		cc.broadcastTyping(chatID, userID, false)
+	}
+}
+
+func (cc *ChatController) broadcastTyping(chatID uint, userID uint, isTyping bool) {
+	memberIDs, ok := cc.chatMemberIDs(chatID, userID)
+	if !ok {
+		return
+	}
+
+	cc.sendTyping(memberIDs, chatID, userID, isTyping)
+}
+
+func (cc *ChatController) sendTyping(memberIDs []uint, chatID uint, userID uint, isTyping bool) {
+	recipientIDs := make([]uint, 0, len(memberIDs))
+	for _, memberID := range memberIDs {
+		if memberID != userID {
+			recipientIDs = append(recipientIDs, memberID)
+		}
+	}
+
+	cc.hub.BroadcastToUsers(recipientIDs, &websocket.Message{
+		Type:      "typing",
+		Ephemeral: true,
+		Data: typingData{
+			ChatID:   chatID,
+			UserID:   userID,
+			IsTyping: isTyping,
+		},
+	})
+}
+
+func (cc *ChatController) chatMemberIDs(chatID uint, userID uint) ([]uint, bool) {
+	memberIDs, err := cc.chatUsecase.GetChatMemberIDs(chatID)
+	if err != nil {
+		return nil, false
+	}
+
+	for _, memberID := range memberIDs {
+		if memberID == userID {
+			return memberIDs, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,18 @@
+package v1
+
+import (
+	"expvar"
+	"net/http"
+
+	"gin-real-time-talk/pkg/websocket"
+)
+
+// NewDebugHandler serves /debug/vars, including the hub's stats. It is not
+// authenticated and is only mounted on the internal debug listener.
+func NewDebugHandler(hub *websocket.Hub) http.Handler {
+	expvar.Publish("websocketHub", expvar.Func(func() any { return hub.Stats() }))
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
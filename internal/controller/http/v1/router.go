@@ -2,13 +2,16 @@ package v1
 
 import (
 	_ "gin-real-time-talk/docs"
+	"gin-real-time-talk/internal/controller/http/v1/attachment"
 	"gin-real-time-talk/internal/controller/http/v1/auth"
 	"gin-real-time-talk/internal/controller/http/v1/chat"
+	"gin-real-time-talk/internal/usecase/attachment_usecase"
 	"gin-real-time-talk/internal/usecase/auth_usecase"
 	"gin-real-time-talk/internal/usecase/repository"
 	"gin-real-time-talk/pkg/email"
 	"gin-real-time-talk/pkg/logger"
 	"gin-real-time-talk/pkg/middleware"
+	"gin-real-time-talk/pkg/storage"
 	"gin-real-time-talk/pkg/websocket"
 
 	"github.com/gin-gonic/gin"
@@ -22,7 +25,7 @@ type Router struct {
 	logger *logger.Logger
 }
 
-func NewRouter(db *gorm.DB, logger *logger.Logger) *gin.Engine {
+func NewRouter(db *gorm.DB, logger *logger.Logger, hub *websocket.Hub, fileStorage storage.Storage, thumbnailWorker *attachment_usecase.ThumbnailWorker) *gin.Engine {
 	_ = &Router{
 		db:     db,
 		logger: logger,
@@ -38,13 +41,11 @@ func NewRouter(db *gorm.DB, logger *logger.Logger) *gin.Engine {
 	emailService := email.NewEmailService()
 	authUsecase := auth_usecase.NewAuthUsecase(userRepo, emailService)
 
-	hub := websocket.NewHub()
-	go hub.Run()
-
 	api := router.Group("/api/v1")
 	{
 		auth.SetupAuthRoutes(api, db, authUsecase)
-		chat.SetupChatRoutes(api, db, authUsecase, hub)
+		chat.SetupChatRoutes(api, db, authUsecase, hub, fileStorage)
+		attachment.SetupAttachmentRoutes(api, db, authUsecase, fileStorage, thumbnailWorker)
 	}
 
 	return router
@@ -0,0 +1,94 @@
+package entity
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ThumbnailStatusPending = "pending"
+	ThumbnailStatusReady   = "ready"
+	ThumbnailStatusFailed  = "failed"
+)
+
+var ThumbnailSizes = []int{64, 320, 1280}
+
+type Thumbnail struct {
+	Size   int    `json:"size"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	URL    string `json:"url"`
+}
+
+type Attachment struct {
+	ID              uint        `gorm:"primaryKey" json:"id"`
+	MessageID       *uint       `gorm:"column:message_id;index" json:"messageId"`
+	UploaderID      uint        `gorm:"column:uploader_id;not null;index" json:"uploaderId"`
+	FileName        string      `gorm:"column:file_name;not null" json:"fileName"`
+	MimeType        string      `gorm:"column:mime_type;not null" json:"mimeType"`
+	Size            int64       `gorm:"column:size;not null" json:"size"`
+	Checksum        string      `gorm:"column:checksum;type:char(64);not null" json:"checksum"`
+	StorageKey      string      `gorm:"column:storage_key;not null;index" json:"-"`
+	Width           *int        `gorm:"column:width" json:"width"`
+	Height          *int        `gorm:"column:height" json:"height"`
+	ThumbnailStatus string      `gorm:"column:thumbnail_status;type:varchar(16);index" json:"-"`
+	URL             string      `gorm:"-" json:"url"`
+	Thumbnails      []Thumbnail `gorm:"-" json:"thumbnails"`
+	CreatedAt       time.Time   `json:"createdAt"`
+}
+
+func (a *Attachment) AfterFind(tx *gorm.DB) error {
+	a.setURL()
+	return nil
+}
+
+func (a *Attachment) AfterCreate(tx *gorm.DB) error {
+	a.setURL()
+	return nil
+}
+
+func (a *Attachment) IsImage() bool {
+	return strings.HasPrefix(a.MimeType, "image/")
+}
+
+func (a *Attachment) ThumbnailKey(size int) string {
+	return fmt.Sprintf("%s_%d.jpg", a.StorageKey, size)
+}
+
+func (a *Attachment) setURL() {
+	a.URL = fmt.Sprintf("/api/v1/attachments/%d", a.ID)
+	a.Thumbnails = []Thumbnail{}
+
+	if a.ThumbnailStatus != ThumbnailStatusReady || a.Width == nil || a.Height == nil {
+		return
+	}
+
+	for _, size := range ThumbnailSizes {
+		width, height := FitWithin(*a.Width, *a.Height, size)
+		a.Thumbnails = append(a.Thumbnails, Thumbnail{
+			Size:   size,
+			Width:  width,
+			Height: height,
+			URL:    fmt.Sprintf("/api/v1/attachments/%d/thumbnails/%d", a.ID, size),
+		})
+	}
+}
+
+func FitWithin(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+
+	if width >= height {
+		return size, max(1, height*size/width)
+	}
+
+	return max(1, width*size/height), size
+}
+
+func (Attachment) TableName() string {
+	return "attachments"
+}
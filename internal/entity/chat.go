@@ -1,17 +1,43 @@
 package entity
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	ChatTypeDirect = "direct"
+	ChatTypeGroup  = "group"
+)
 
 type Chat struct {
-	ID              uint      `gorm:"primaryKey" json:"id"`
-	UserID          uint      `gorm:"column:user_id;not null" json:"userId"`
-	User            User      `gorm:"foreignKey:UserID" json:"user"`
-	LastMessageID   *uint     `gorm:"column:last_message_id" json:"lastMessageId"`
-	LastMessage     *Message  `gorm:"foreignKey:LastMessageID" json:"lastMessage"`
-	LastMessageText *string   `gorm:"column:last_message_text;type:text" json:"lastMessageText"`
-	UnreadCount     int       `gorm:"column:unread_count;default:0" json:"unreadCount"`
-	CreatedAt       time.Time `json:"createdAt"`
-	UpdatedAt       time.Time `json:"updatedAt"`
+	ID                           uint         `gorm:"primaryKey" json:"id"`
+	Type                         string       `gorm:"column:type;type:varchar(16);not null;default:direct" json:"type"`
+	Title                        *string      `gorm:"column:title;type:text" json:"title"`
+	Photo                        *string      `gorm:"column:photo;type:text" json:"photo"`
+	DirectKey                    *string      `gorm:"column:direct_key;type:varchar(64);uniqueIndex" json:"-"`
+	User                         *User        `gorm:"-" json:"user"`
+	Members                      []ChatMember `gorm:"foreignKey:ChatID" json:"members,omitempty"`
+	LastMessageID                *uint        `gorm:"column:last_message_id" json:"lastMessageId"`
+	LastMessage                  *Message     `gorm:"foreignKey:LastMessageID" json:"lastMessage"`
+	LastMessageText              *string      `gorm:"column:last_message_text;type:text" json:"lastMessageText"`
+	PinnedMessage                *Message     `gorm:"-" json:"pinnedMessage"`
+	UnreadCount                  int          `gorm:"-" json:"unreadCount"`
+	LastReadMessageID            *uint        `gorm:"-" json:"lastReadMessageId"`
+	OthersLastReadMessageID      *uint        `gorm:"-" json:"othersLastReadMessageId"`
+	OthersLastDeliveredMessageID *uint        `gorm:"-" json:"othersLastDeliveredMessageId"`
+	CreatedAt                    time.Time    `json:"createdAt"`
+	UpdatedAt                    time.Time    `json:"updatedAt"`
+}
+
+// DirectChatKey identifies the direct chat between two users regardless of
+// who wrote first.
+func DirectChatKey(userID uint, peerID uint) string {
+	return fmt.Sprintf("%d:%d", min(userID, peerID), max(userID, peerID))
+}
+
+func (c *Chat) IsGroup() bool {
+	return c.Type == ChatTypeGroup
 }
 
 func (Chat) TableName() string {
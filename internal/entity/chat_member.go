@@ -0,0 +1,33 @@
+package entity
+
+import "time"
+
+const (
+	ChatMemberRoleOwner  = "owner"
+	ChatMemberRoleAdmin  = "admin"
+	ChatMemberRoleMember = "member"
+)
+
+type ChatMember struct {
+	ChatID                 uint       `gorm:"primaryKey;column:chat_id" json:"chatId"`
+	UserID                 uint       `gorm:"primaryKey;column:user_id;index" json:"userId"`
+	User                   User       `gorm:"foreignKey:UserID" json:"user"`
+	Role                   string     `gorm:"column:role;type:varchar(16);not null;default:member" json:"role"`
+	JoinedAt               time.Time  `gorm:"column:joined_at;not null" json:"joinedAt"`
+	LastReadMessageID      *uint      `gorm:"column:last_read_message_id" json:"lastReadMessageId"`
+	LastReadAt             *time.Time `gorm:"column:last_read_at" json:"lastReadAt"`
+	LastDeliveredMessageID *uint      `gorm:"column:last_delivered_message_id" json:"lastDeliveredMessageId"`
+	LastDeliveredAt        *time.Time `gorm:"column:last_delivered_at" json:"lastDeliveredAt"`
+}
+
+func (m *ChatMember) IsOwner() bool {
+	return m.Role == ChatMemberRoleOwner
+}
+
+func (m *ChatMember) CanManageMembers() bool {
+	return m.Role == ChatMemberRoleOwner || m.Role == ChatMemberRoleAdmin
+}
+
+func (ChatMember) TableName() string {
+	return "chat_members"
+}
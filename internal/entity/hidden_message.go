@@ -0,0 +1,14 @@
+package entity
+
+import "time"
+
+type HiddenMessage struct {
+	MessageID uint      `gorm:"primaryKey;column:message_id" json:"messageId"`
+	Message   *Message  `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE" json:"-"`
+	UserID    uint      `gorm:"primaryKey;column:user_id" json:"userId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (HiddenMessage) TableName() string {
+	return "hidden_messages"
+}
@@ -0,0 +1,12 @@
+package interfaces
+
+import "gin-real-time-talk/internal/entity"
+
+type AttachmentRepository interface {
+	Create(attachment *entity.Attachment) error
+	GetByID(id uint) (*entity.Attachment, error)
+	GetByIDs(ids []uint) ([]entity.Attachment, error)
+	CountByStorageKey(storageKey string) (int64, error)
+	GetByThumbnailStatus(status string, limit int) ([]entity.Attachment, error)
+	UpdateImageInfo(storageKey string, width int, height int, thumbnailStatus string) error
+}
@@ -0,0 +1,13 @@
+package interfaces
+
+import (
+	"io"
+
+	"gin-real-time-talk/internal/entity"
+)
+
+type AttachmentUsecase interface {
+	Upload(userID uint, fileName string, size int64, file io.Reader) (*entity.Attachment, error)
+	Open(attachmentID uint, userID uint) (*entity.Attachment, io.ReadCloser, error)
+	OpenThumbnail(attachmentID uint, userID uint, size int) (io.ReadCloser, error)
+}
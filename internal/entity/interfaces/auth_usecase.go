@@ -1,6 +1,10 @@
 package interfaces
 
-import "gin-real-time-talk/internal/entity"
+import (
+	"time"
+
+	"gin-real-time-talk/internal/entity"
+)
 
 type AuthUsecase interface {
 	Register(email, password, firstName, lastName string) (*entity.User, error)
@@ -9,4 +13,6 @@ type AuthUsecase interface {
 	VerifyTwoFactorCode(email, code string) (string, string, *entity.User, error)
 	RefreshToken(refreshToken string) (string, string, *entity.User, error)
 	ValidateAccessToken(token string) (*entity.User, error)
+	ValidateAccessTokenExpiry(token string) (*entity.User, time.Time, error)
+	CheckUserActive(userID uint) error
 }
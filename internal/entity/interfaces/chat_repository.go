@@ -4,8 +4,25 @@ import "gin-real-time-talk/internal/entity"
 
 type ChatRepository interface {
 	GetByUserID(userID uint, limit int, nextToken string, search string) ([]entity.Chat, string, error)
-	GetByID(id uint) (*entity.Chat, error)
+	GetByID(id uint, userID uint) (*entity.Chat, error)
+	GetMembers(chatID uint) ([]entity.ChatMember, error)
+	GetMember(chatID uint, userID uint) (*entity.ChatMember, error)
+	GetMemberIDs(chatID uint) ([]uint, error)
+	GetContactIDs(userID uint) ([]uint, error)
+	IsMember(chatID uint, userID uint) (bool, error)
+	AddMembers(members []entity.ChatMember) error
+	RemoveMember(chatID uint, userID uint) error
+	UpdateMemberRole(chatID uint, userID uint, role string) error
+	TransferOwnership(chatID uint, fromUserID uint, toUserID uint) error
 	FindOrCreateChatByUsers(senderID uint, recipientID uint) (*entity.Chat, error)
+	UpdateLastMessage(chatID uint, message *entity.Message) error
+	MarkRead(chatID uint, userID uint, messageID uint) (bool, error)
+	MarkDelivered(chatID uint, userID uint, messageID uint) (bool, error)
+	MarkAllDelivered(userID uint) ([]entity.ChatMember, error)
+	GetPins(chatID uint) ([]entity.PinnedMessage, error)
+	Pin(pin *entity.PinnedMessage, notice *entity.Message) (bool, error)
+	Unpin(chatID uint, messageID uint, notice *entity.Message) (bool, error)
 	Create(chat *entity.Chat) error
 	Update(chat *entity.Chat) error
+	Delete(chatID uint) ([]entity.Attachment, error)
 }
@@ -1,9 +1,42 @@
 package interfaces
 
-import "gin-real-time-talk/internal/entity"
+import (
+	"time"
+
+	"gin-real-time-talk/internal/entity"
+)
+
+type CreateMessageInput struct {
+	ChatID          uint
+	RecipientID     uint
+	Text            string
+	ReplyToID       *uint
+	ThreadRootID    *uint
+	AttachmentIDs   []uint
+	ClientMessageID string
+}
 
 type ChatUsecase interface {
 	GetUserChats(userID uint, limit int, nextToken string, search string) ([]entity.Chat, string, error)
 	GetChatMessages(chatID uint, userID uint, limit int, nextToken string) ([]entity.Message, string, error)
-	CreateMessage(senderID uint, recipientID uint, text string) (*entity.Message, error)
+	GetThreadMessages(chatID uint, messageID uint, userID uint, limit int, nextToken string) ([]entity.Message, string, error)
+	CreateMessage(senderID uint, input CreateMessageInput) (*entity.Message, bool, error)
+	ForwardMessages(userID uint, messageIDs []uint, chatIDs []uint, recipientIDs []uint) ([]entity.Message, error)
+	EditMessage(chatID uint, messageID uint, userID uint, text string) (*entity.Message, error)
+	DeleteMessage(chatID uint, messageID uint, userID uint, scope string) (*entity.Message, error)
+	AddReaction(chatID uint, messageID uint, userID uint, emoji string) ([]entity.MessageReaction, error)
+	RemoveReaction(chatID uint, messageID uint, userID uint, emoji string) ([]entity.MessageReaction, error)
+	MarkChatRead(chatID uint, userID uint, messageID uint) (*entity.ChatMember, bool, error)
+	MarkDelivered(chatID uint, userID uint, messageID uint) (*entity.ChatMember, bool, error)
+	MarkAllDelivered(userID uint) ([]entity.ChatMember, error)
+	GetPinnedMessages(chatID uint, userID uint) ([]entity.PinnedMessage, error)
+	PinMessage(chatID uint, messageID uint, userID uint) (*entity.Message, error)
+	UnpinMessage(chatID uint, messageID uint, userID uint) (*entity.Message, error)
+	CreateGroupChat(ownerID uint, title string, photo *string, memberIDs []uint) (*entity.Chat, error)
+	GetChatMembers(chatID uint, userID uint) ([]entity.ChatMember, error)
+	GetChatMemberIDs(chatID uint) ([]uint, error)
+	UpdatePresence(userID uint, online bool) (*time.Time, []uint, error)
+	AddChatMembers(chatID uint, userID uint, memberIDs []uint) ([]entity.ChatMember, error)
+	RemoveChatMember(chatID uint, userID uint, memberID uint) ([]entity.ChatMember, error)
+	UpdateChatMemberRole(chatID uint, userID uint, memberID uint, role string) ([]entity.ChatMember, error)
 }
@@ -1,9 +1,25 @@
 package interfaces
 
-import "gin-real-time-talk/internal/entity"
+import (
+	"errors"
+
+	"gin-real-time-talk/internal/entity"
+)
+
+// ErrClientMessageIDTaken is returned by Create when the author already has a
+// message with the same client message ID.
+var ErrClientMessageIDTaken = errors.New("client message ID is already taken")
 
 type MessageRepository interface {
-	GetByChatID(chatID uint, limit int, nextToken string) ([]entity.Message, string, error)
+	GetByChatID(chatID uint, userID uint, limit int, nextToken string) ([]entity.Message, string, error)
+	GetThreadReplies(rootID uint, userID uint, limit int, nextToken string) ([]entity.Message, string, error)
 	GetByID(id uint) (*entity.Message, error)
+	GetByClientMessageID(authorID uint, clientMessageID string) (*entity.Message, error)
 	Create(message *entity.Message) error
+	Edit(message *entity.Message, text string) error
+	Hide(messageID uint, userID uint) error
+	DeleteForEveryone(message *entity.Message) error
+	GetReactions(messageID uint) ([]entity.MessageReaction, error)
+	AddReaction(reaction *entity.MessageReaction) error
+	RemoveReaction(messageID uint, userID uint, emoji string) error
 }
@@ -1,10 +1,16 @@
 package interfaces
 
-import "gin-real-time-talk/internal/entity"
+import (
+	"time"
+
+	"gin-real-time-talk/internal/entity"
+)
 
 type UserRepository interface {
 	Create(user *entity.User) error
 	GetByEmail(email string) (*entity.User, error)
 	GetByID(id uint) (*entity.User, error)
+	GetByIDs(ids []uint) ([]entity.User, error)
 	Update(user *entity.User) error
+	UpdateLastSeen(userID uint, lastSeenAt time.Time) error
 }
@@ -2,16 +2,55 @@ package entity
 
 import "time"
 
+const (
+	MessageTypeText   = "text"
+	MessageTypeSystem = "system"
+)
+
+const (
+	MessageDeleteScopeMe       = "me"
+	MessageDeleteScopeEveryone = "everyone"
+)
+
 type Message struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Text      string    `gorm:"type:text;not null" json:"text"`
-	AuthorID  uint      `gorm:"column:author_id;not null" json:"authorId"`
-	Author    User      `gorm:"foreignKey:AuthorID" json:"author"`
-	ChatID    uint      `gorm:"column:chat_id;not null" json:"chatId"`
-	Chat      *Chat     `gorm:"foreignKey:ChatID" json:"chat,omitempty"`
-	IsRead    bool      `gorm:"column:is_read;default:false" json:"isRead"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID                     uint              `gorm:"primaryKey" json:"id"`
+	Type                   string            `gorm:"column:type;type:varchar(16);not null;default:text" json:"type"`
+	Text                   string            `gorm:"type:text;not null" json:"text"`
+	AuthorID               uint              `gorm:"column:author_id;not null;uniqueIndex:idx_messages_author_client_message_id,priority:1" json:"authorId"`
+	Author                 User              `gorm:"foreignKey:AuthorID" json:"author"`
+	ClientMessageID        *string           `gorm:"column:client_message_id;type:varchar(64);uniqueIndex:idx_messages_author_client_message_id,priority:2" json:"clientMessageId"`
+	ChatID                 uint              `gorm:"column:chat_id;not null" json:"chatId"`
+	Chat                   *Chat             `gorm:"foreignKey:ChatID" json:"chat,omitempty"`
+	ReplyToID              *uint             `gorm:"column:reply_to_id;index" json:"replyToId"`
+	ReplyTo                *MessagePreview   `gorm:"-" json:"replyTo,omitempty"`
+	ThreadRootID           *uint             `gorm:"column:thread_root_id;index" json:"threadRootId"`
+	ThreadReplyCount       int               `gorm:"column:thread_reply_count;not null;default:0" json:"threadReplyCount"`
+	LastThreadReplyAt      *time.Time        `gorm:"column:last_thread_reply_at" json:"lastThreadReplyAt"`
+	ForwardedFromMessageID *uint             `gorm:"column:forwarded_from_message_id" json:"forwardedFromMessageId"`
+	ForwardedFromUserID    *uint             `gorm:"column:forwarded_from_user_id" json:"forwardedFromUserId"`
+	ForwardedFrom          *User             `gorm:"foreignKey:ForwardedFromUserID" json:"forwardedFrom,omitempty"`
+	Attachments            []Attachment      `gorm:"foreignKey:MessageID" json:"attachments"`
+	Reactions              []ReactionSummary `gorm:"-" json:"reactions"`
+	EditedAt               *time.Time        `gorm:"column:edited_at" json:"editedAt"`
+	DeletedAt              *time.Time        `gorm:"column:deleted_at" json:"deletedAt"`
+	CreatedAt              time.Time         `json:"createdAt"`
+	UpdatedAt              time.Time         `json:"updatedAt"`
+}
+
+func (m *Message) IsSystem() bool {
+	return m.Type == MessageTypeSystem
+}
+
+func (m *Message) IsDeleted() bool {
+	return m.DeletedAt != nil
+}
+
+func (m *Message) IsThreadReply() bool {
+	return m.ThreadRootID != nil
+}
+
+func (m *Message) IsForwarded() bool {
+	return m.ForwardedFromMessageID != nil
 }
 
 func (Message) TableName() string {
@@ -0,0 +1,15 @@
+package entity
+
+import "time"
+
+type MessageEdit struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	MessageID uint      `gorm:"column:message_id;not null;index" json:"messageId"`
+	Message   *Message  `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE" json:"-"`
+	Text      string    `gorm:"type:text;not null" json:"text"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (MessageEdit) TableName() string {
+	return "message_edits"
+}
@@ -0,0 +1,26 @@
+package entity
+
+const messagePreviewLength = 100
+
+type MessagePreview struct {
+	ID         uint   `json:"id"`
+	AuthorID   uint   `json:"authorId"`
+	AuthorName string `json:"authorName"`
+	Text       string `json:"text"`
+	Deleted    bool   `json:"deleted"`
+}
+
+func NewMessagePreview(message *Message) *MessagePreview {
+	text := []rune(message.Text)
+	if len(text) > messagePreviewLength {
+		text = append(text[:messagePreviewLength], '…')
+	}
+
+	return &MessagePreview{
+		ID:         message.ID,
+		AuthorID:   message.AuthorID,
+		AuthorName: message.Author.FullName,
+		Text:       string(text),
+		Deleted:    message.IsDeleted(),
+	}
+}
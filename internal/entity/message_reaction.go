@@ -0,0 +1,70 @@
+package entity
+
+import "time"
+
+type MessageReaction struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	MessageID uint      `gorm:"column:message_id;not null;uniqueIndex:idx_message_reactions_unique" json:"messageId"`
+	Message   *Message  `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE" json:"-"`
+	UserID    uint      `gorm:"column:user_id;not null;uniqueIndex:idx_message_reactions_unique" json:"userId"`
+	Emoji     string    `gorm:"column:emoji;type:varchar(32);not null;uniqueIndex:idx_message_reactions_unique" json:"emoji"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (MessageReaction) TableName() string {
+	return "message_reactions"
+}
+
+type ReactionSummary struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+func SummarizeReactions(reactions []MessageReaction, viewerID uint) []ReactionSummary {
+	summaries := []ReactionSummary{}
+	indexes := make(map[string]int)
+
+	for _, reaction := range reactions {
+		idx, exists := indexes[reaction.Emoji]
+		if !exists {
+			idx = len(summaries)
+			indexes[reaction.Emoji] = idx
+			summaries = append(summaries, ReactionSummary{Emoji: reaction.Emoji})
+		}
+
+		summaries[idx].Count++
+		if reaction.UserID == viewerID {
+			summaries[idx].Reacted = true
+		}
+	}
+
+	return summaries
+}
+
+// ReactionUsers is the same for every viewer, so one event can go to all chat
+// members; each client finds out whether it reacted from UserIDs.
+type ReactionUsers struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	UserIDs []uint `json:"userIds"`
+}
+
+func GroupReactions(reactions []MessageReaction) []ReactionUsers {
+	groups := []ReactionUsers{}
+	indexes := make(map[string]int)
+
+	for _, reaction := range reactions {
+		idx, exists := indexes[reaction.Emoji]
+		if !exists {
+			idx = len(groups)
+			indexes[reaction.Emoji] = idx
+			groups = append(groups, ReactionUsers{Emoji: reaction.Emoji})
+		}
+
+		groups[idx].Count++
+		groups[idx].UserIDs = append(groups[idx].UserIDs, reaction.UserID)
+	}
+
+	return groups
+}
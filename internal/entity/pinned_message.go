@@ -0,0 +1,16 @@
+package entity
+
+import "time"
+
+type PinnedMessage struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	ChatID     uint      `gorm:"column:chat_id;not null;uniqueIndex:idx_pinned_messages_unique" json:"chatId"`
+	MessageID  uint      `gorm:"column:message_id;not null;uniqueIndex:idx_pinned_messages_unique" json:"messageId"`
+	Message    *Message  `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE" json:"message"`
+	PinnedByID uint      `gorm:"column:pinned_by_id;not null" json:"pinnedById"`
+	CreatedAt  time.Time `json:"pinnedAt"`
+}
+
+func (PinnedMessage) TableName() string {
+	return "pinned_messages"
+}
@@ -19,6 +19,8 @@ type User struct {
 	TwoFactorCode       string     `gorm:"column:two_factor_code" json:"-"`
 	TwoFactorExpiresAt  *time.Time `gorm:"column:two_factor_expires_at" json:"-"`
 	TwoFactorVerifiedAt *time.Time `gorm:"column:two_factor_verified_at" json:"-"`
+	LastSeenAt          *time.Time `gorm:"column:last_seen_at" json:"lastSeenAt"`
+	Online              bool       `gorm:"-" json:"online"`
 	CreatedAt           time.Time  `json:"createdAt"`
 	UpdatedAt           time.Time  `json:"updatedAt"`
 }
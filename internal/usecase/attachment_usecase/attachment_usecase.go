@@ -0,0 +1,252 @@
+package attachment_usecase
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gin-real-time-talk/config"
+	"gin-real-time-talk/internal/entity"
+	"gin-real-time-talk/internal/entity/interfaces"
+	"gin-real-time-talk/pkg/storage"
+)
+
+const (
+	sniffLength       = 512
+	maxFileNameLength = 255
+)
+
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+type attachmentUsecase struct {
+	attachmentRepo interfaces.AttachmentRepository
+	messageRepo    interfaces.MessageRepository
+	chatRepo       interfaces.ChatRepository
+	storage        storage.Storage
+	thumbnails     *ThumbnailWorker
+}
+
+func NewAttachmentUsecase(attachmentRepo interfaces.AttachmentRepository, messageRepo interfaces.MessageRepository, chatRepo interfaces.ChatRepository, storage storage.Storage, thumbnails *ThumbnailWorker) interfaces.AttachmentUsecase {
+	return &attachmentUsecase{
+		attachmentRepo: attachmentRepo,
+		messageRepo:    messageRepo,
+		chatRepo:       chatRepo,
+		storage:        storage,
+		thumbnails:     thumbnails,
+	}
+}
+
+func (u *attachmentUsecase) Upload(userID uint, fileName string, size int64, file io.Reader) (*entity.Attachment, error) {
+	maxSize := config.Env.Upload.MaxSize
+	if size > maxSize {
+		return nil, fmt.Errorf("file is too large, maximum size is %d bytes", maxSize)
+	}
+
+	fileName = sanitizeFileName(fileName)
+	if fileName == "" {
+		return nil, errors.New("file name cannot be empty")
+	}
+
+	reader := bufio.NewReaderSize(file, sniffLength)
+	head, err := reader.Peek(sniffLength)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	if len(head) == 0 {
+		return nil, errors.New("file cannot be empty")
+	}
+
+	mimeType := http.DetectContentType(head)
+	if !isAllowedType(mimeType) {
+		return nil, fmt.Errorf("file type %s is not allowed", mimeType)
+	}
+
+	storageKey, err := newStorageKey()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	limited := io.LimitReader(reader, maxSize+1)
+
+	if err := u.storage.Save(storageKey, io.TeeReader(limited, io.MultiWriter(hash, counter))); err != nil {
+		return nil, fmt.Errorf("failed to store file: %w", err)
+	}
+
+	if counter.n > maxSize {
+		_ = u.storage.Delete(storageKey)
+		return nil, fmt.Errorf("file is too large, maximum size is %d bytes", maxSize)
+	}
+
+	attachment := &entity.Attachment{
+		UploaderID: userID,
+		FileName:   fileName,
+		MimeType:   mimeType,
+		Size:       counter.n,
+		Checksum:   hex.EncodeToString(hash.Sum(nil)),
+		StorageKey: storageKey,
+	}
+
+	if thumbnailTypes[mimeType] {
+		attachment.ThumbnailStatus = entity.ThumbnailStatusPending
+	}
+
+	if err := u.attachmentRepo.Create(attachment); err != nil {
+		_ = u.storage.Delete(storageKey)
+		return nil, err
+	}
+
+	if attachment.ThumbnailStatus == entity.ThumbnailStatusPending {
+		u.thumbnails.Enqueue(*attachment)
+	}
+
+	return attachment, nil
+}
+
+func (u *attachmentUsecase) Open(attachmentID uint, userID uint) (*entity.Attachment, io.ReadCloser, error) {
+	attachment, err := u.attachmentRepo.GetByID(attachmentID)
+	if err != nil {
+		return nil, nil, errors.New("attachment not found")
+	}
+
+	if err := u.checkAccess(attachment, userID); err != nil {
+		return nil, nil, err
+	}
+
+	file, err := u.storage.Open(attachment.StorageKey)
+	if err != nil {
+		return nil, nil, errors.New("attachment file not found")
+	}
+
+	return attachment, file, nil
+}
+
+func (u *attachmentUsecase) OpenThumbnail(attachmentID uint, userID uint, size int) (io.ReadCloser, error) {
+	attachment, err := u.attachmentRepo.GetByID(attachmentID)
+	if err != nil {
+		return nil, errors.New("attachment not found")
+	}
+
+	if err := u.checkAccess(attachment, userID); err != nil {
+		return nil, err
+	}
+
+	if !isThumbnailSize(size) {
+		return nil, errors.New("thumbnail size is not supported")
+	}
+
+	if attachment.ThumbnailStatus != entity.ThumbnailStatusReady {
+		return nil, errors.New("thumbnail is not available")
+	}
+
+	file, err := u.storage.Open(attachment.ThumbnailKey(size))
+	if err != nil {
+		return nil, errors.New("thumbnail is not available")
+	}
+
+	return file, nil
+}
+
+func (u *attachmentUsecase) checkAccess(attachment *entity.Attachment, userID uint) error {
+	if attachment.MessageID == nil {
+		if attachment.UploaderID != userID {
+			return errors.New("attachment not found")
+		}
+		return nil
+	}
+
+	message, err := u.messageRepo.GetByID(*attachment.MessageID)
+	if err != nil {
+		return errors.New("attachment not found")
+	}
+
+	isMember, err := u.chatRepo.IsMember(message.ChatID, userID)
+	if err != nil {
+		return err
+	}
+
+	if !isMember {
+		return errors.New("attachment not found")
+	}
+
+	return nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func isAllowedType(mimeType string) bool {
+	mediaType := strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0])
+	for _, allowed := range config.Env.Upload.AllowedTypes {
+		if strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed) {
+			return true
+		}
+		if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func isThumbnailSize(size int) bool {
+	for _, thumbnailSize := range entity.ThumbnailSizes {
+		if size == thumbnailSize {
+			return true
+		}
+	}
+	return false
+}
+
+func sanitizeFileName(fileName string) string {
+	fileName = filepath.Base(strings.ReplaceAll(fileName, "\\", "/"))
+	fileName = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, fileName)
+	fileName = strings.TrimSpace(fileName)
+
+	if fileName == "." || fileName == "/" {
+		return ""
+	}
+
+	if len(fileName) > maxFileNameLength {
+		ext := filepath.Ext(fileName)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		fileName = strings.ToValidUTF8(fileName[:maxFileNameLength-len(ext)], "") + ext
+	}
+
+	return fileName
+}
+
+func newStorageKey() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate storage key: %w", err)
+	}
+
+	return time.Now().UTC().Format("2006/01/02") + "/" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,135 @@
+package attachment_usecase
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"gin-real-time-talk/internal/entity"
+	"gin-real-time-talk/internal/entity/interfaces"
+	"gin-real-time-talk/pkg/logger"
+	"gin-real-time-talk/pkg/storage"
+	"gin-real-time-talk/pkg/thumbnail"
+)
+
+const (
+	thumbnailQueueSize    = 256
+	pendingBatchSize      = 100
+	pendingRescanInterval = time.Minute
+)
+
+type ThumbnailWorker struct {
+	attachmentRepo interfaces.AttachmentRepository
+	storage        storage.Storage
+	queue          chan entity.Attachment
+	logger         *logger.Logger
+}
+
+func NewThumbnailWorker(attachmentRepo interfaces.AttachmentRepository, storage storage.Storage) *ThumbnailWorker {
+	return &ThumbnailWorker{
+		attachmentRepo: attachmentRepo,
+		storage:        storage,
+		queue:          make(chan entity.Attachment, thumbnailQueueSize),
+		logger:         logger.New(),
+	}
+}
+
+func (w *ThumbnailWorker) Enqueue(attachment entity.Attachment) {
+	select {
+	case w.queue <- attachment:
+	default:
+		w.logger.Info(fmt.Sprintf("Thumbnail queue is full, attachment %d will be picked up by the next rescan", attachment.ID))
+	}
+}
+
+// Run processes queued attachments until ctx is cancelled. Pending
+// attachments that never made it into the queue, such as ones dropped while it
+// was full or copied by a forward, are picked up by a periodic rescan.
+func (w *ThumbnailWorker) Run(ctx context.Context) {
+	w.processPending(ctx)
+
+	rescan := time.NewTicker(pendingRescanInterval)
+	defer rescan.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case attachment := <-w.queue:
+			w.process(attachment)
+		case <-rescan.C:
+			w.processPending(ctx)
+		}
+	}
+}
+
+func (w *ThumbnailWorker) processPending(ctx context.Context) {
+	lastID := uint(0)
+	for ctx.Err() == nil {
+		attachments, err := w.attachmentRepo.GetByThumbnailStatus(entity.ThumbnailStatusPending, pendingBatchSize)
+		if err != nil {
+			w.logger.Error(fmt.Sprintf("Failed to load pending thumbnails: %v", err))
+			return
+		}
+
+		if len(attachments) == 0 || attachments[len(attachments)-1].ID == lastID {
+			return
+		}
+
+		for _, attachment := range attachments {
+			w.process(attachment)
+		}
+		lastID = attachments[len(attachments)-1].ID
+	}
+}
+
+func (w *ThumbnailWorker) process(attachment entity.Attachment) {
+	width, height, err := w.generate(&attachment)
+	if err != nil {
+		w.logger.Error(fmt.Sprintf("Failed to generate thumbnails for attachment %d: %v", attachment.ID, err))
+		if err := w.attachmentRepo.UpdateImageInfo(attachment.StorageKey, 0, 0, entity.ThumbnailStatusFailed); err != nil {
+			w.logger.Error(fmt.Sprintf("Failed to update attachment %d: %v", attachment.ID, err))
+		}
+		return
+	}
+
+	if err := w.attachmentRepo.UpdateImageInfo(attachment.StorageKey, width, height, entity.ThumbnailStatusReady); err != nil {
+		w.logger.Error(fmt.Sprintf("Failed to update attachment %d: %v", attachment.ID, err))
+	}
+}
+
+func (w *ThumbnailWorker) generate(attachment *entity.Attachment) (int, int, error) {
+	file, err := w.storage.Open(attachment.StorageKey)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	img, err := thumbnail.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	bounds := img.Bounds()
+	for _, size := range entity.ThumbnailSizes {
+		width, height := entity.FitWithin(bounds.Dx(), bounds.Dy(), size)
+
+		var buf bytes.Buffer
+		if err := thumbnail.EncodeJPEG(&buf, thumbnail.Resize(img, width, height)); err != nil {
+			return 0, 0, err
+		}
+
+		if err := w.storage.Save(attachment.ThumbnailKey(size), &buf); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return bounds.Dx(), bounds.Dy(), nil
+}
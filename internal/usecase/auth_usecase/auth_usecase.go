@@ -188,17 +188,42 @@ func (u *authUsecase) RefreshToken(refreshToken string) (string, string, *entity
 }
 
 func (u *authUsecase) ValidateAccessToken(token string) (*entity.User, error) {
+	user, _, err := u.ValidateAccessTokenExpiry(token)
+	return user, err
+}
+
+func (u *authUsecase) ValidateAccessTokenExpiry(token string) (*entity.User, time.Time, error) {
 	claims, err := jwt.ValidateAccessToken(token)
 	if err != nil {
-		return nil, errors.New("invalid token")
+		return nil, time.Time{}, errors.New("invalid token")
+	}
+
+	if claims.ExpiresAt == nil {
+		return nil, time.Time{}, errors.New("token has no expiry")
 	}
 
 	user, err := u.userRepo.GetByID(claims.UserID)
 	if err != nil {
-		return nil, errors.New("user not found")
+		return nil, time.Time{}, errors.New("user not found")
 	}
 
-	return user, nil
+	return user, claims.ExpiresAt.Time, nil
+}
+
+// CheckUserActive reports whether the user could still log in: they exist and
+// their email is verified. Only open sockets are held to it; a token is
+// accepted as long as its user exists.
+func (u *authUsecase) CheckUserActive(userID uint) error {
+	user, err := u.userRepo.GetByID(userID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+
+	if !user.EmailVerified {
+		return errors.New("user is not active")
+	}
+
+	return nil
 }
 
 func generateCode() string {
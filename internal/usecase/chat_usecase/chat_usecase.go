@@ -2,21 +2,39 @@ package chat_usecase
 
 import (
 	"errors"
+	"strings"
+	"time"
 
+	"gin-real-time-talk/config"
 	"gin-real-time-talk/internal/entity"
 	"gin-real-time-talk/internal/entity/interfaces"
 	"gin-real-time-talk/pkg/pagination"
+	"gin-real-time-talk/pkg/storage"
+)
+
+const (
+	maxEmojiLength     = 32
+	maxForwardMessages = 100
+	maxForwardTargets  = 20
+	maxAttachments     = 10
+	maxClientMessageID = 64
 )
 
 type chatUsecase struct {
-	chatRepo    interfaces.ChatRepository
-	messageRepo interfaces.MessageRepository
+	chatRepo       interfaces.ChatRepository
+	messageRepo    interfaces.MessageRepository
+	userRepo       interfaces.UserRepository
+	attachmentRepo interfaces.AttachmentRepository
+	storage        storage.Storage
 }
 
-func NewChatUsecase(chatRepo interfaces.ChatRepository, messageRepo interfaces.MessageRepository) interfaces.ChatUsecase {
+func NewChatUsecase(chatRepo interfaces.ChatRepository, messageRepo interfaces.MessageRepository, userRepo interfaces.UserRepository, attachmentRepo interfaces.AttachmentRepository, storage storage.Storage) interfaces.ChatUsecase {
 	return &chatUsecase{
-		chatRepo:    chatRepo,
-		messageRepo: messageRepo,
+		chatRepo:       chatRepo,
+		messageRepo:    messageRepo,
+		userRepo:       userRepo,
+		attachmentRepo: attachmentRepo,
+		storage:        storage,
 	}
 }
 
@@ -32,18 +50,38 @@ func (u *chatUsecase) GetUserChats(userID uint, limit int, nextToken string, sea
 }
 
 func (u *chatUsecase) GetChatMessages(chatID uint, userID uint, limit int, nextToken string) ([]entity.Message, string, error) {
-	chat, err := u.chatRepo.GetByID(chatID)
+	isMember, err := u.chatRepo.IsMember(chatID, userID)
 	if err != nil {
+		return nil, "", err
+	}
+
+	if !isMember {
 		return nil, "", errors.New("chat not found")
 	}
 
-	if chat.UserID != userID {
-		return nil, "", errors.New("access denied")
+	limit = pagination.NormalizeLimit(limit)
+
+	messages, token, err := u.messageRepo.GetByChatID(chatID, userID, limit, nextToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return messages, token, nil
+}
+
+func (u *chatUsecase) GetThreadMessages(chatID uint, messageID uint, userID uint, limit int, nextToken string) ([]entity.Message, string, error) {
+	root, err := u.getChatMessage(chatID, messageID, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if root.IsThreadReply() {
+		return nil, "", errors.New("message is not a thread root")
 	}
 
 	limit = pagination.NormalizeLimit(limit)
 
-	messages, token, err := u.messageRepo.GetByChatID(chatID, limit, nextToken)
+	messages, token, err := u.messageRepo.GetThreadReplies(root.ID, userID, limit, nextToken)
 	if err != nil {
 		return nil, "", err
 	}
@@ -51,38 +89,912 @@ func (u *chatUsecase) GetChatMessages(chatID uint, userID uint, limit int, nextT
 	return messages, token, nil
 }
 
-func (u *chatUsecase) CreateMessage(senderID uint, recipientID uint, text string) (*entity.Message, error) {
-	if text == "" {
+func (u *chatUsecase) CreateMessage(senderID uint, input interfaces.CreateMessageInput) (*entity.Message, bool, error) {
+	input.ClientMessageID = strings.TrimSpace(input.ClientMessageID)
+	if input.ClientMessageID == "" {
+		message, err := u.createMessage(senderID, input)
+		return message, false, err
+	}
+
+	if len(input.ClientMessageID) > maxClientMessageID {
+		return nil, false, errors.New("client message ID is too long")
+	}
+
+	if existing, err := u.messageRepo.GetByClientMessageID(senderID, input.ClientMessageID); err == nil {
+		return u.replayMessage(existing, senderID, input)
+	}
+
+	message, err := u.createMessage(senderID, input)
+	if errors.Is(err, interfaces.ErrClientMessageIDTaken) {
+		existing, err := u.messageRepo.GetByClientMessageID(senderID, input.ClientMessageID)
+		if err != nil {
+			return nil, false, err
+		}
+		return u.replayMessage(existing, senderID, input)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return message, false, nil
+}
+
+func (u *chatUsecase) createMessage(senderID uint, input interfaces.CreateMessageInput) (*entity.Message, error) {
+	input.AttachmentIDs = uniqueIDs(input.AttachmentIDs, 0)
+	if len(input.AttachmentIDs) > maxAttachments {
+		return nil, errors.New("too many attachments")
+	}
+
+	if strings.TrimSpace(input.Text) == "" && len(input.AttachmentIDs) == 0 {
 		return nil, errors.New("message text cannot be empty")
 	}
 
-	chat, err := u.chatRepo.FindOrCreateChatByUsers(senderID, recipientID)
+	attachments, err := u.getUploadedAttachments(senderID, input.AttachmentIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	chatID, err := u.resolveTargetChat(senderID, input)
 	if err != nil {
 		return nil, err
 	}
 
+	if input.ReplyToID != nil {
+		replyTo, err := u.messageRepo.GetByID(*input.ReplyToID)
+		if err != nil || replyTo.ChatID != chatID {
+			return nil, errors.New("replied message not found in this chat")
+		}
+
+		if replyTo.IsDeleted() {
+			return nil, errors.New("cannot reply to a deleted message")
+		}
+	}
+
+	if input.ThreadRootID != nil {
+		root, err := u.messageRepo.GetByID(*input.ThreadRootID)
+		if err != nil || root.ChatID != chatID {
+			return nil, errors.New("thread root message not found in this chat")
+		}
+
+		if root.IsDeleted() {
+			return nil, errors.New("cannot reply in a thread of a deleted message")
+		}
+
+		if root.IsThreadReply() {
+			return nil, errors.New("thread replies cannot start their own thread")
+		}
+	}
+
 	message := &entity.Message{
-		Text:     text,
-		AuthorID: senderID,
-		ChatID:   chat.ID,
-		IsRead:   false,
+		Type:         entity.MessageTypeText,
+		Text:         input.Text,
+		AuthorID:     senderID,
+		ChatID:       chatID,
+		ReplyToID:    input.ReplyToID,
+		ThreadRootID: input.ThreadRootID,
+		Attachments:  attachments,
 	}
 
-	if err := u.messageRepo.Create(message); err != nil {
+	if input.ClientMessageID != "" {
+		message.ClientMessageID = &input.ClientMessageID
+	}
+
+	return u.saveMessage(message)
+}
+
+func (u *chatUsecase) ForwardMessages(userID uint, messageIDs []uint, chatIDs []uint, recipientIDs []uint) ([]entity.Message, error) {
+	messageIDs = uniqueIDs(messageIDs, 0)
+	if len(messageIDs) == 0 {
+		return nil, errors.New("no messages to forward")
+	}
+
+	if len(messageIDs) > maxForwardMessages {
+		return nil, errors.New("too many messages to forward")
+	}
+
+	sources := make([]*entity.Message, 0, len(messageIDs))
+	for _, messageID := range messageIDs {
+		source, err := u.messageRepo.GetByID(messageID)
+		if err != nil {
+			return nil, errors.New("message not found")
+		}
+
+		isMember, err := u.chatRepo.IsMember(source.ChatID, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isMember {
+			return nil, errors.New("message not found")
+		}
+
+		if source.IsDeleted() || source.IsSystem() {
+			return nil, errors.New("cannot forward this message")
+		}
+
+		sources = append(sources, source)
+	}
+
+	targetChatIDs, err := u.resolveForwardTargets(userID, chatIDs, recipientIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var forwarded []entity.Message
+	for _, chatID := range targetChatIDs {
+		for _, source := range sources {
+			message := &entity.Message{
+				Type:                   entity.MessageTypeText,
+				Text:                   source.Text,
+				AuthorID:               userID,
+				ChatID:                 chatID,
+				ForwardedFromMessageID: &source.ID,
+				ForwardedFromUserID:    &source.AuthorID,
+				Attachments:            copyAttachments(source.Attachments, userID),
+			}
+
+			if source.IsForwarded() {
+				message.ForwardedFromMessageID = source.ForwardedFromMessageID
+				message.ForwardedFromUserID = source.ForwardedFromUserID
+			}
+
+			saved, err := u.saveMessage(message)
+			if err != nil {
+				return nil, err
+			}
+
+			forwarded = append(forwarded, *saved)
+		}
+	}
+
+	return forwarded, nil
+}
+
+func (u *chatUsecase) EditMessage(chatID uint, messageID uint, userID uint, text string) (*entity.Message, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, errors.New("message text cannot be empty")
+	}
+
+	message, err := u.getChatMessage(chatID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if message.IsDeleted() {
+		return nil, errors.New("message has been deleted")
+	}
+
+	if message.IsSystem() {
+		return nil, errors.New("system messages cannot be edited")
+	}
+
+	if message.AuthorID != userID {
+		return nil, errors.New("only the author can edit this message")
+	}
+
+	if time.Since(message.CreatedAt) > config.Env.Chat.MessageEditWindow {
+		return nil, errors.New("message can no longer be edited")
+	}
+
+	if message.Text == text {
+		return message, nil
+	}
+
+	if err := u.messageRepo.Edit(message, text); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func (u *chatUsecase) DeleteMessage(chatID uint, messageID uint, userID uint, scope string) (*entity.Message, error) {
+	message, err := u.getChatMessage(chatID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch scope {
+	case entity.MessageDeleteScopeMe:
+		if err := u.messageRepo.Hide(message.ID, userID); err != nil {
+			return nil, err
+		}
+		return message, nil
+
+	case entity.MessageDeleteScopeEveryone:
+		if message.IsDeleted() {
+			return nil, errors.New("message has been deleted")
+		}
+
+		if message.AuthorID != userID {
+			member, err := u.chatRepo.GetMember(chatID, userID)
+			if err != nil {
+				return nil, err
+			}
+
+			chat, err := u.chatRepo.GetByID(chatID, userID)
+			if err != nil {
+				return nil, err
+			}
+
+			if !chat.IsGroup() || !member.CanManageMembers() {
+				return nil, errors.New("not enough rights to delete this message")
+			}
+		}
+
+		attachments := message.Attachments
+		if err := u.messageRepo.DeleteForEveryone(message); err != nil {
+			return nil, err
+		}
+		u.removeStoredFiles(attachments)
+		return message, nil
+
+	default:
+		return nil, errors.New("invalid delete scope")
+	}
+}
+
+func (u *chatUsecase) AddReaction(chatID uint, messageID uint, userID uint, emoji string) ([]entity.MessageReaction, error) {
+	emoji, err := normalizeEmoji(emoji)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := u.getChatMessage(chatID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if message.IsDeleted() {
+		return nil, errors.New("message has been deleted")
+	}
+
+	reaction := &entity.MessageReaction{
+		MessageID: message.ID,
+		UserID:    userID,
+		Emoji:     emoji,
+	}
+
+	if err := u.messageRepo.AddReaction(reaction); err != nil {
+		return nil, err
+	}
+
+	return u.messageRepo.GetReactions(message.ID)
+}
+
+func (u *chatUsecase) RemoveReaction(chatID uint, messageID uint, userID uint, emoji string) ([]entity.MessageReaction, error) {
+	emoji, err := normalizeEmoji(emoji)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := u.getChatMessage(chatID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.messageRepo.RemoveReaction(message.ID, userID, emoji); err != nil {
+		return nil, err
+	}
+
+	return u.messageRepo.GetReactions(message.ID)
+}
+
+func (u *chatUsecase) MarkChatRead(chatID uint, userID uint, messageID uint) (*entity.ChatMember, bool, error) {
+	isMember, err := u.chatRepo.IsMember(chatID, userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !isMember {
+		return nil, false, errors.New("chat not found")
+	}
+
+	if messageID == 0 {
+		chat, err := u.chatRepo.GetByID(chatID, userID)
+		if err != nil {
+			return nil, false, errors.New("chat not found")
+		}
+
+		if chat.LastMessageID == nil {
+			return nil, false, errors.New("chat has no messages")
+		}
+		messageID = *chat.LastMessageID
+	} else {
+		message, err := u.messageRepo.GetByID(messageID)
+		if err != nil || message.ChatID != chatID {
+			return nil, false, errors.New("message not found")
+		}
+	}
+
+	advanced, err := u.chatRepo.MarkRead(chatID, userID, messageID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	member, err := u.chatRepo.GetMember(chatID, userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return member, advanced, nil
+}
+
+func (u *chatUsecase) MarkDelivered(chatID uint, userID uint, messageID uint) (*entity.ChatMember, bool, error) {
+	message, err := u.messageRepo.GetByID(messageID)
+	if err != nil || message.ChatID != chatID {
+		return nil, false, errors.New("message not found")
+	}
+
+	advanced, err := u.chatRepo.MarkDelivered(chatID, userID, messageID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !advanced {
+		return nil, false, nil
+	}
+
+	member, err := u.chatRepo.GetMember(chatID, userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return member, true, nil
+}
+
+func (u *chatUsecase) MarkAllDelivered(userID uint) ([]entity.ChatMember, error) {
+	return u.chatRepo.MarkAllDelivered(userID)
+}
+
+func (u *chatUsecase) GetPinnedMessages(chatID uint, userID uint) ([]entity.PinnedMessage, error) {
+	isMember, err := u.chatRepo.IsMember(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isMember {
+		return nil, errors.New("chat not found")
+	}
+
+	return u.chatRepo.GetPins(chatID)
+}
+
+func (u *chatUsecase) PinMessage(chatID uint, messageID uint, userID uint) (*entity.Message, error) {
+	message, err := u.getPinnableMessage(chatID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pin := &entity.PinnedMessage{
+		ChatID:     chatID,
+		MessageID:  message.ID,
+		PinnedByID: userID,
+	}
+	notice := &entity.Message{
+		Type:      entity.MessageTypeSystem,
+		Text:      "pinned a message",
+		AuthorID:  userID,
+		ChatID:    chatID,
+		ReplyToID: &message.ID,
+	}
+
+	pinned, err := u.chatRepo.Pin(pin, notice)
+	if err != nil {
+		return nil, err
+	}
+
+	if !pinned {
+		return nil, errors.New("message is already pinned")
+	}
+
+	return u.loadSavedMessage(notice.ID)
+}
+
+func (u *chatUsecase) UnpinMessage(chatID uint, messageID uint, userID uint) (*entity.Message, error) {
+	message, err := u.getPinnableMessage(chatID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	notice := &entity.Message{
+		Type:      entity.MessageTypeSystem,
+		Text:      "unpinned a message",
+		AuthorID:  userID,
+		ChatID:    chatID,
+		ReplyToID: &message.ID,
+	}
+
+	unpinned, err := u.chatRepo.Unpin(chatID, message.ID, notice)
+	if err != nil {
+		return nil, err
+	}
+
+	if !unpinned {
+		return nil, errors.New("message is not pinned")
+	}
+
+	return u.loadSavedMessage(notice.ID)
+}
+
+func (u *chatUsecase) CreateGroupChat(ownerID uint, title string, photo *string, memberIDs []uint) (*entity.Chat, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return nil, errors.New("group title cannot be empty")
+	}
+
+	memberIDs = uniqueIDs(memberIDs, ownerID)
+	if err := u.ensureUsersExist(memberIDs); err != nil {
+		return nil, err
+	}
+
+	joinedAt := time.Now()
+	members := []entity.ChatMember{
+		{UserID: ownerID, Role: entity.ChatMemberRoleOwner, JoinedAt: joinedAt},
+	}
+	for _, memberID := range memberIDs {
+		members = append(members, entity.ChatMember{
+			UserID:   memberID,
+			Role:     entity.ChatMemberRoleMember,
+			JoinedAt: joinedAt,
+		})
+	}
+
+	chat := &entity.Chat{
+		Type:    entity.ChatTypeGroup,
+		Title:   &title,
+		Photo:   photo,
+		Members: members,
+	}
+
+	if err := u.chatRepo.Create(chat); err != nil {
+		return nil, err
+	}
+
+	members, err := u.chatRepo.GetMembers(chat.ID)
+	if err != nil {
+		return nil, err
+	}
+	chat.Members = members
+
+	return chat, nil
+}
+
+func (u *chatUsecase) GetChatMembers(chatID uint, userID uint) ([]entity.ChatMember, error) {
+	isMember, err := u.chatRepo.IsMember(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isMember {
+		return nil, errors.New("chat not found")
+	}
+
+	return u.chatRepo.GetMembers(chatID)
+}
+
+func (u *chatUsecase) GetChatMemberIDs(chatID uint) ([]uint, error) {
+	return u.chatRepo.GetMemberIDs(chatID)
+}
+
+func (u *chatUsecase) UpdatePresence(userID uint, online bool) (*time.Time, []uint, error) {
+	var lastSeenAt *time.Time
+	if !online {
+		now := time.Now()
+		if err := u.userRepo.UpdateLastSeen(userID, now); err != nil {
+			return nil, nil, err
+		}
+		lastSeenAt = &now
+	}
+
+	contactIDs, err := u.chatRepo.GetContactIDs(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return lastSeenAt, contactIDs, nil
+}
+
+func (u *chatUsecase) AddChatMembers(chatID uint, userID uint, memberIDs []uint) ([]entity.ChatMember, error) {
+	actor, err := u.getGroupMember(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !actor.CanManageMembers() {
+		return nil, errors.New("only owners and admins can add members")
+	}
+
+	memberIDs = uniqueIDs(memberIDs, userID)
+	if len(memberIDs) == 0 {
+		return nil, errors.New("no members to add")
+	}
+
+	if err := u.ensureUsersExist(memberIDs); err != nil {
+		return nil, err
+	}
+
+	joinedAt := time.Now()
+	members := make([]entity.ChatMember, 0, len(memberIDs))
+	for _, memberID := range memberIDs {
+		members = append(members, entity.ChatMember{
+			ChatID:   chatID,
+			UserID:   memberID,
+			Role:     entity.ChatMemberRoleMember,
+			JoinedAt: joinedAt,
+		})
+	}
+
+	if err := u.chatRepo.AddMembers(members); err != nil {
+		return nil, err
+	}
+
+	return u.chatRepo.GetMembers(chatID)
+}
+
+func (u *chatUsecase) RemoveChatMember(chatID uint, userID uint, memberID uint) ([]entity.ChatMember, error) {
+	actor, err := u.getGroupMember(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if memberID == userID {
+		memberIDs, err := u.chatRepo.GetMemberIDs(chatID)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(memberIDs) == 1 {
+			attachments, err := u.chatRepo.Delete(chatID)
+			if err != nil {
+				return nil, err
+			}
+			u.removeStoredFiles(attachments)
+			return []entity.ChatMember{}, nil
+		}
+
+		if actor.IsOwner() {
+			return nil, errors.New("owner must transfer ownership before leaving")
+		}
+	} else {
+		target, err := u.chatRepo.GetMember(chatID, memberID)
+		if err != nil {
+			return nil, errors.New("member not found")
+		}
+
+		switch {
+		case actor.IsOwner():
+		case actor.Role == entity.ChatMemberRoleAdmin && target.Role == entity.ChatMemberRoleMember:
+		default:
+			return nil, errors.New("not enough rights to remove this member")
+		}
+	}
+
+	if err := u.chatRepo.RemoveMember(chatID, memberID); err != nil {
+		return nil, err
+	}
+
+	return u.chatRepo.GetMembers(chatID)
+}
+
+func (u *chatUsecase) UpdateChatMemberRole(chatID uint, userID uint, memberID uint, role string) ([]entity.ChatMember, error) {
+	actor, err := u.getGroupMember(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !actor.IsOwner() {
+		return nil, errors.New("only the owner can change member roles")
+	}
+
+	if memberID == userID {
+		return nil, errors.New("cannot change your own role")
+	}
+
+	if _, err := u.chatRepo.GetMember(chatID, memberID); err != nil {
+		return nil, errors.New("member not found")
+	}
+
+	switch role {
+	case entity.ChatMemberRoleOwner:
+		err = u.chatRepo.TransferOwnership(chatID, userID, memberID)
+	case entity.ChatMemberRoleAdmin, entity.ChatMemberRoleMember:
+		err = u.chatRepo.UpdateMemberRole(chatID, memberID, role)
+	default:
+		return nil, errors.New("invalid role")
+	}
+	if err != nil {
 		return nil, err
 	}
 
-	chat.LastMessageID = &message.ID
-	chat.LastMessageText = &message.Text
+	return u.chatRepo.GetMembers(chatID)
+}
 
-	if err := u.chatRepo.Update(chat); err != nil {
+func (u *chatUsecase) saveMessage(message *entity.Message) (*entity.Message, error) {
+	if err := u.messageRepo.Create(message); err != nil {
 		return nil, err
 	}
 
-	messageWithRelations, err := u.messageRepo.GetByID(message.ID)
+	if !message.IsThreadReply() {
+		if err := u.chatRepo.UpdateLastMessage(message.ChatID, message); err != nil {
+			return nil, err
+		}
+
+		if _, err := u.chatRepo.MarkRead(message.ChatID, message.AuthorID, message.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return u.loadSavedMessage(message.ID)
+}
+
+// loadSavedMessage reads a message that was just stored along with its
+// relations. It cannot have reactions yet.
+func (u *chatUsecase) loadSavedMessage(messageID uint) (*entity.Message, error) {
+	messageWithRelations, err := u.messageRepo.GetByID(messageID)
 	if err != nil {
 		return nil, err
 	}
+	messageWithRelations.Reactions = []entity.ReactionSummary{}
 
 	return messageWithRelations, nil
 }
+
+func (u *chatUsecase) replayMessage(message *entity.Message, senderID uint, input interfaces.CreateMessageInput) (*entity.Message, bool, error) {
+	if input.ChatID != 0 && message.ChatID != input.ChatID {
+		return nil, false, errors.New("client message ID was already used in another chat")
+	}
+
+	if input.ChatID == 0 {
+		chat, err := u.chatRepo.GetByID(message.ChatID, senderID)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if chat.User == nil || chat.User.ID != input.RecipientID {
+			return nil, false, errors.New("client message ID was already used for another recipient")
+		}
+	}
+
+	reactions, err := u.messageRepo.GetReactions(message.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	message.Reactions = entity.SummarizeReactions(reactions, senderID)
+
+	return message, true, nil
+}
+
+func (u *chatUsecase) resolveTargetChat(senderID uint, input interfaces.CreateMessageInput) (uint, error) {
+	if input.ChatID != 0 {
+		isMember, err := u.chatRepo.IsMember(input.ChatID, senderID)
+		if err != nil {
+			return 0, err
+		}
+
+		if !isMember {
+			return 0, errors.New("chat not found")
+		}
+
+		return input.ChatID, nil
+	}
+
+	if input.RecipientID == 0 {
+		return 0, errors.New("chat or recipient is required")
+	}
+
+	if input.RecipientID == senderID {
+		return 0, errors.New("cannot send message to yourself")
+	}
+
+	if _, err := u.userRepo.GetByID(input.RecipientID); err != nil {
+		return 0, errors.New("recipient not found")
+	}
+
+	chat, err := u.chatRepo.FindOrCreateChatByUsers(senderID, input.RecipientID)
+	if err != nil {
+		return 0, err
+	}
+
+	return chat.ID, nil
+}
+
+func (u *chatUsecase) resolveForwardTargets(userID uint, chatIDs []uint, recipientIDs []uint) ([]uint, error) {
+	chatIDs = uniqueIDs(chatIDs, 0)
+	recipientIDs = uniqueIDs(recipientIDs, userID)
+
+	if len(chatIDs)+len(recipientIDs) == 0 {
+		return nil, errors.New("no chats to forward to")
+	}
+
+	if len(chatIDs)+len(recipientIDs) > maxForwardTargets {
+		return nil, errors.New("too many chats to forward to")
+	}
+
+	for _, chatID := range chatIDs {
+		isMember, err := u.chatRepo.IsMember(chatID, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isMember {
+			return nil, errors.New("chat not found")
+		}
+	}
+
+	for _, recipientID := range recipientIDs {
+		chatID, err := u.resolveTargetChat(userID, interfaces.CreateMessageInput{RecipientID: recipientID})
+		if err != nil {
+			return nil, err
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+
+	return uniqueIDs(chatIDs, 0), nil
+}
+
+func (u *chatUsecase) getChatMessage(chatID uint, messageID uint, userID uint) (*entity.Message, error) {
+	isMember, err := u.chatRepo.IsMember(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isMember {
+		return nil, errors.New("chat not found")
+	}
+
+	message, err := u.messageRepo.GetByID(messageID)
+	if err != nil || message.ChatID != chatID {
+		return nil, errors.New("message not found")
+	}
+
+	return message, nil
+}
+
+func (u *chatUsecase) getPinnableMessage(chatID uint, messageID uint, userID uint) (*entity.Message, error) {
+	message, err := u.getChatMessage(chatID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	chat, err := u.chatRepo.GetByID(chatID, userID)
+	if err != nil {
+		return nil, errors.New("chat not found")
+	}
+
+	if chat.IsGroup() {
+		member, err := u.chatRepo.GetMember(chatID, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !member.CanManageMembers() {
+			return nil, errors.New("only owners and admins can pin messages")
+		}
+	}
+
+	if message.IsDeleted() || message.IsSystem() || message.IsThreadReply() {
+		return nil, errors.New("this message cannot be pinned")
+	}
+
+	return message, nil
+}
+
+func (u *chatUsecase) getGroupMember(chatID uint, userID uint) (*entity.ChatMember, error) {
+	member, err := u.chatRepo.GetMember(chatID, userID)
+	if err != nil {
+		return nil, errors.New("chat not found")
+	}
+
+	chat, err := u.chatRepo.GetByID(chatID, userID)
+	if err != nil {
+		return nil, errors.New("chat not found")
+	}
+
+	if !chat.IsGroup() {
+		return nil, errors.New("operation is only available for group chats")
+	}
+
+	return member, nil
+}
+
+func (u *chatUsecase) getUploadedAttachments(userID uint, attachmentIDs []uint) ([]entity.Attachment, error) {
+	if len(attachmentIDs) == 0 {
+		return nil, nil
+	}
+
+	attachments, err := u.attachmentRepo.GetByIDs(attachmentIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(attachments) != len(attachmentIDs) {
+		return nil, errors.New("attachment not found")
+	}
+
+	for _, attachment := range attachments {
+		if attachment.UploaderID != userID {
+			return nil, errors.New("attachment not found")
+		}
+
+		if attachment.MessageID != nil {
+			return nil, errors.New("attachment is already used in another message")
+		}
+	}
+
+	return attachments, nil
+}
+
+func (u *chatUsecase) removeStoredFiles(attachments []entity.Attachment) {
+	for _, attachment := range attachments {
+		count, err := u.attachmentRepo.CountByStorageKey(attachment.StorageKey)
+		if err != nil || count > 0 {
+			continue
+		}
+		_ = u.storage.Delete(attachment.StorageKey)
+		for _, size := range entity.ThumbnailSizes {
+			_ = u.storage.Delete(attachment.ThumbnailKey(size))
+		}
+	}
+}
+
+func (u *chatUsecase) ensureUsersExist(userIDs []uint) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	users, err := u.userRepo.GetByIDs(userIDs)
+	if err != nil {
+		return err
+	}
+
+	if len(users) != len(userIDs) {
+		return errors.New("some users were not found")
+	}
+
+	return nil
+}
+
+func copyAttachments(attachments []entity.Attachment, uploaderID uint) []entity.Attachment {
+	copies := make([]entity.Attachment, 0, len(attachments))
+	for _, attachment := range attachments {
+		copies = append(copies, entity.Attachment{
+			UploaderID:      uploaderID,
+			FileName:        attachment.FileName,
+			MimeType:        attachment.MimeType,
+			Size:            attachment.Size,
+			Checksum:        attachment.Checksum,
+			StorageKey:      attachment.StorageKey,
+			Width:           attachment.Width,
+			Height:          attachment.Height,
+			ThumbnailStatus: attachment.ThumbnailStatus,
+		})
+	}
+	return copies
+}
+
+func normalizeEmoji(emoji string) (string, error) {
+	emoji = strings.TrimSpace(emoji)
+	if emoji == "" {
+		return "", errors.New("emoji cannot be empty")
+	}
+
+	if len(emoji) > maxEmojiLength || strings.ContainsAny(emoji, " \t\n") {
+		return "", errors.New("invalid emoji")
+	}
+
+	return emoji, nil
+}
+
+func uniqueIDs(ids []uint, exclude uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	result := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if id == 0 || id == exclude || seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+	}
+	return result
+}
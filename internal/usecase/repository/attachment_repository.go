@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"gin-real-time-talk/internal/entity"
+	"gin-real-time-talk/internal/entity/interfaces"
+
+	"gorm.io/gorm"
+)
+
+type attachmentRepository struct {
+	db *gorm.DB
+}
+
+func NewAttachmentRepository(db *gorm.DB) interfaces.AttachmentRepository {
+	return &attachmentRepository{
+		db: db,
+	}
+}
+
+func (r *attachmentRepository) Create(attachment *entity.Attachment) error {
+	return r.db.Create(attachment).Error
+}
+
+func (r *attachmentRepository) GetByID(id uint) (*entity.Attachment, error) {
+	var attachment entity.Attachment
+	err := r.db.First(&attachment, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+func (r *attachmentRepository) GetByIDs(ids []uint) ([]entity.Attachment, error) {
+	var attachments []entity.Attachment
+	if len(ids) == 0 {
+		return attachments, nil
+	}
+
+	err := r.db.Where("id IN ?", ids).Order("id").Find(&attachments).Error
+	if err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+func (r *attachmentRepository) CountByStorageKey(storageKey string) (int64, error) {
+	var count int64
+	err := r.db.Model(&entity.Attachment{}).
+		Where(&entity.Attachment{StorageKey: storageKey}).
+		Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *attachmentRepository) GetByThumbnailStatus(status string, limit int) ([]entity.Attachment, error) {
+	var attachments []entity.Attachment
+	err := r.db.Where(&entity.Attachment{ThumbnailStatus: status}).
+		Order("id").
+		Limit(limit).
+		Find(&attachments).Error
+	if err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+func (r *attachmentRepository) UpdateImageInfo(storageKey string, width int, height int, thumbnailStatus string) error {
+	updates := map[string]interface{}{
+		"thumbnail_status": thumbnailStatus,
+	}
+	if width > 0 && height > 0 {
+		updates["width"] = width
+		updates["height"] = height
+	}
+
+	return r.db.Model(&entity.Attachment{}).
+		Where(&entity.Attachment{StorageKey: storageKey}).
+		UpdateColumns(updates).Error
+}
@@ -1,11 +1,15 @@
 package repository
 
 import (
+	"errors"
+	"time"
+
 	"gin-real-time-talk/internal/entity"
 	"gin-real-time-talk/internal/entity/interfaces"
 	"gin-real-time-talk/pkg/pagination"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type chatRepository struct {
@@ -21,153 +25,521 @@ func NewChatRepository(db *gorm.DB) interfaces.ChatRepository {
 func (r *chatRepository) GetByUserID(userID uint, limit int, nextToken string, search string) ([]entity.Chat, string, error) {
 	limit = pagination.NormalizeLimit(limit)
 
-	allChatsQuery := r.db.Model(&entity.Chat{}).
-		Where("chats.user_id = ?", userID)
+	query := r.db.Model(&entity.Chat{}).
+		Select("chats.*").
+		Joins("JOIN chat_members ON chat_members.chat_id = chats.id AND chat_members.user_id = ?", userID).
+		Preload("LastMessage.Author").
+		Order("chats.updated_at DESC, chats.id DESC")
+
+	if search != "" {
+		pattern := "%" + search + "%"
+		query = query.Where(`(chats.title ILIKE ? OR chats.last_message_text ILIKE ? OR EXISTS (
+			SELECT 1 FROM chat_members peers
+			JOIN users ON users.id = peers.user_id
+			WHERE chats.type = ?
+				AND peers.chat_id = chats.id
+				AND peers.user_id <> ?
+				AND users.full_name ILIKE ?
+		))`, pattern, pattern, entity.ChatTypeDirect, userID, pattern)
+	}
+
+	if nextToken != "" {
+		cursorID, err := pagination.DecodeToken(nextToken)
+		if err == nil && cursorID > 0 {
+			var cursorChat entity.Chat
+			if err := r.db.First(&cursorChat, cursorID).Error; err == nil {
+				query = query.Where("(chats.updated_at, chats.id) < (?, ?)", cursorChat.UpdatedAt, cursorChat.ID)
+			}
+		}
+	}
+
+	query = query.Limit(limit + 1)
+
+	var chats []entity.Chat
+	if err := query.Find(&chats).Error; err != nil {
+		return nil, "", err
+	}
+
+	var hasNext bool
+	if len(chats) > limit {
+		hasNext = true
+		chats = chats[:limit]
+	}
+
+	if err := r.attachPeers(chats, userID); err != nil {
+		return nil, "", err
+	}
 
-	var allChats []entity.Chat
-	if err := allChatsQuery.Find(&allChats).Error; err != nil {
+	if err := r.attachReadState(chats, userID); err != nil {
 		return nil, "", err
 	}
 
-	if len(allChats) == 0 {
-		return []entity.Chat{}, "", nil
+	if err := r.hideHiddenLastMessages(chats, userID); err != nil {
+		return nil, "", err
+	}
+
+	if err := r.attachPinnedMessages(chats); err != nil {
+		return nil, "", err
+	}
+
+	var token string
+	if hasNext && len(chats) > 0 {
+		lastChat := chats[len(chats)-1]
+		token = pagination.EncodeToken(lastChat.ID)
+	}
+
+	return chats, token, nil
+}
+
+func (r *chatRepository) GetByID(id uint, userID uint) (*entity.Chat, error) {
+	var chat entity.Chat
+	err := r.db.Preload("LastMessage.Author").First(&chat, id).Error
+	if err != nil {
+		return nil, err
 	}
 
-	allChatIDs := make([]uint, len(allChats))
-	for i := range allChats {
-		allChatIDs[i] = allChats[i].ID
+	chats := []entity.Chat{chat}
+
+	if err := r.attachPeers(chats, userID); err != nil {
+		return nil, err
 	}
 
-	var allOtherUsers []struct {
-		ChatID uint `gorm:"column:chat_id"`
-		UserID uint `gorm:"column:user_id"`
+	if err := r.attachReadState(chats, userID); err != nil {
+		return nil, err
 	}
 
-	r.db.Raw(`
-		SELECT DISTINCT ON (messages.chat_id) 
-			messages.chat_id, 
-			messages.author_id as user_id
-		FROM messages
-		WHERE messages.chat_id IN ? 
-			AND messages.author_id != ?
-		ORDER BY messages.chat_id, messages.created_at DESC
-	`, allChatIDs, userID).Scan(&allOtherUsers)
+	if err := r.hideHiddenLastMessages(chats, userID); err != nil {
+		return nil, err
+	}
 
-	allOtherUserMap := make(map[uint]uint)
-	for _, ou := range allOtherUsers {
-		allOtherUserMap[ou.ChatID] = ou.UserID
+	if err := r.attachPinnedMessages(chats); err != nil {
+		return nil, err
 	}
 
-	var allOtherUserIDs []uint
-	for _, uid := range allOtherUserMap {
-		allOtherUserIDs = append(allOtherUserIDs, uid)
+	return &chats[0], nil
+}
+
+func (r *chatRepository) GetMembers(chatID uint) ([]entity.ChatMember, error) {
+	var members []entity.ChatMember
+	err := r.db.Where(&entity.ChatMember{ChatID: chatID}).
+		Preload("User").
+		Order("joined_at, user_id").
+		Find(&members).Error
+	if err != nil {
+		return nil, err
 	}
+	return members, nil
+}
 
-	var allUsers []entity.User
-	if len(allOtherUserIDs) > 0 {
-		r.db.Where("id IN ?", allOtherUserIDs).Find(&allUsers)
+func (r *chatRepository) GetMember(chatID uint, userID uint) (*entity.ChatMember, error) {
+	var member entity.ChatMember
+	err := r.db.Where(&entity.ChatMember{ChatID: chatID, UserID: userID}).
+		Preload("User").
+		First(&member).Error
+	if err != nil {
+		return nil, err
 	}
+	return &member, nil
+}
 
-	allUserMap := make(map[uint]entity.User)
-	for _, user := range allUsers {
-		allUserMap[user.ID] = user
+func (r *chatRepository) GetMemberIDs(chatID uint) ([]uint, error) {
+	var userIDs []uint
+	err := r.db.Model(&entity.ChatMember{}).
+		Where(&entity.ChatMember{ChatID: chatID}).
+		Pluck("user_id", &userIDs).Error
+	if err != nil {
+		return nil, err
 	}
+	return userIDs, nil
+}
 
-	for i := range allChats {
-		if otherUserID, exists := allOtherUserMap[allChats[i].ID]; exists {
-			if otherUser, exists := allUserMap[otherUserID]; exists {
-				allChats[i].User = otherUser
-			}
+func (r *chatRepository) GetContactIDs(userID uint) ([]uint, error) {
+	var userIDs []uint
+	err := r.db.Model(&entity.ChatMember{}).
+		Distinct("chat_members.user_id").
+		Joins("JOIN chat_members mine ON mine.chat_id = chat_members.chat_id AND mine.user_id = ?", userID).
+		Where("chat_members.user_id <> ?", userID).
+		Pluck("chat_members.user_id", &userIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+func (r *chatRepository) IsMember(chatID uint, userID uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&entity.ChatMember{}).
+		Where(&entity.ChatMember{ChatID: chatID, UserID: userID}).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *chatRepository) AddMembers(members []entity.ChatMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&members).Error
+}
+
+func (r *chatRepository) RemoveMember(chatID uint, userID uint) error {
+	return r.db.Where(&entity.ChatMember{ChatID: chatID, UserID: userID}).
+		Delete(&entity.ChatMember{}).Error
+}
+
+func (r *chatRepository) UpdateMemberRole(chatID uint, userID uint, role string) error {
+	return r.db.Model(&entity.ChatMember{}).
+		Where(&entity.ChatMember{ChatID: chatID, UserID: userID}).
+		Update("role", role).Error
+}
+
+func (r *chatRepository) TransferOwnership(chatID uint, fromUserID uint, toUserID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&entity.ChatMember{}).
+			Where(&entity.ChatMember{ChatID: chatID, UserID: fromUserID}).
+			Update("role", entity.ChatMemberRoleAdmin).Error; err != nil {
+			return err
 		}
+
+		return tx.Model(&entity.ChatMember{}).
+			Where(&entity.ChatMember{ChatID: chatID, UserID: toUserID}).
+			Update("role", entity.ChatMemberRoleOwner).Error
+	})
+}
+
+func (r *chatRepository) FindOrCreateChatByUsers(senderID uint, recipientID uint) (*entity.Chat, error) {
+	directKey := entity.DirectChatKey(senderID, recipientID)
+
+	chat, err := r.getDirectChat(directKey)
+	if err == nil {
+		return chat, nil
 	}
 
-	var unreadMessages []struct {
-		ChatID   uint `gorm:"column:chat_id"`
-		AuthorID uint `gorm:"column:author_id"`
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
 	}
 
-	if len(allChatIDs) > 0 {
-		r.db.Model(&entity.Message{}).
-			Select("chat_id, author_id").
-			Where("chat_id IN ? AND is_read = ?", allChatIDs, false).
-			Find(&unreadMessages)
+	newChat := entity.Chat{
+		Type:      entity.ChatTypeDirect,
+		DirectKey: &directKey,
 	}
 
-	unreadCountMap := make(map[uint]int)
-	for _, msg := range unreadMessages {
-		if expectedAuthorID, exists := allOtherUserMap[msg.ChatID]; exists && msg.AuthorID == expectedAuthorID {
-			unreadCountMap[msg.ChatID]++
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "direct_key"}},
+			DoNothing: true,
+		}).Create(&newChat)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return errDirectChatExists
 		}
+
+		joinedAt := time.Now()
+		members := []entity.ChatMember{
+			{ChatID: newChat.ID, UserID: senderID, Role: entity.ChatMemberRoleMember, JoinedAt: joinedAt},
+			{ChatID: newChat.ID, UserID: recipientID, Role: entity.ChatMemberRoleMember, JoinedAt: joinedAt},
+		}
+
+		return tx.Create(&members).Error
+	})
+	if errors.Is(err, errDirectChatExists) {
+		return r.getDirectChat(directKey)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &newChat, nil
+}
+
+// errDirectChatExists means a concurrent request created the direct chat first.
+var errDirectChatExists = errors.New("direct chat already exists")
+
+func (r *chatRepository) getDirectChat(directKey string) (*entity.Chat, error) {
+	var chat entity.Chat
+	err := r.db.Where(&entity.Chat{Type: entity.ChatTypeDirect, DirectKey: &directKey}).First(&chat).Error
+	if err != nil {
+		return nil, err
+	}
+	return &chat, nil
+}
+
+func (r *chatRepository) UpdateLastMessage(chatID uint, message *entity.Message) error {
+	return updateLastMessage(r.db, chatID, message)
+}
 
-	for i := range allChats {
-		if _, exists := allOtherUserMap[allChats[i].ID]; exists {
-			allChats[i].UnreadCount = unreadCountMap[allChats[i].ID]
-		} else {
-			allChats[i].UnreadCount = 0
+func updateLastMessage(db *gorm.DB, chatID uint, message *entity.Message) error {
+	return db.Model(&entity.Chat{ID: chatID}).Updates(map[string]interface{}{
+		"last_message_id":   message.ID,
+		"last_message_text": message.Text,
+	}).Error
+}
+
+func (r *chatRepository) MarkRead(chatID uint, userID uint, messageID uint) (bool, error) {
+	return markRead(r.db, chatID, userID, messageID)
+}
+
+func markRead(db *gorm.DB, chatID uint, userID uint, messageID uint) (bool, error) {
+	now := time.Now()
+	result := db.Model(&entity.ChatMember{}).
+		Where("chat_id = ? AND user_id = ?", chatID, userID).
+		Where("last_read_message_id IS NULL OR last_read_message_id < ?", messageID).
+		UpdateColumns(map[string]interface{}{
+			"last_read_message_id":      messageID,
+			"last_read_at":              now,
+			"last_delivered_message_id": gorm.Expr("GREATEST(COALESCE(last_delivered_message_id, 0), ?)", messageID),
+			"last_delivered_at":         gorm.Expr("CASE WHEN COALESCE(last_delivered_message_id, 0) < ? THEN ? ELSE last_delivered_at END", messageID, now),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *chatRepository) MarkDelivered(chatID uint, userID uint, messageID uint) (bool, error) {
+	result := r.db.Model(&entity.ChatMember{}).
+		Where("chat_id = ? AND user_id = ?", chatID, userID).
+		Where("last_delivered_message_id IS NULL OR last_delivered_message_id < ?", messageID).
+		UpdateColumns(map[string]interface{}{
+			"last_delivered_message_id": messageID,
+			"last_delivered_at":         time.Now(),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *chatRepository) MarkAllDelivered(userID uint) ([]entity.ChatMember, error) {
+	var members []entity.ChatMember
+	err := r.db.Raw(`
+		UPDATE chat_members
+		SET last_delivered_message_id = chats.last_message_id, last_delivered_at = ?
+		FROM chats
+		WHERE chats.id = chat_members.chat_id
+			AND chat_members.user_id = ?
+			AND chats.last_message_id IS NOT NULL
+			AND (chat_members.last_delivered_message_id IS NULL OR chat_members.last_delivered_message_id < chats.last_message_id)
+		RETURNING chat_members.*
+	`, time.Now(), userID).Scan(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (r *chatRepository) GetPins(chatID uint) ([]entity.PinnedMessage, error) {
+	var pins []entity.PinnedMessage
+	err := r.db.Where(&entity.PinnedMessage{ChatID: chatID}).
+		Preload("Message.Author").
+		Order("created_at, id").
+		Find(&pins).Error
+	if err != nil {
+		return nil, err
+	}
+	return pins, nil
+}
+
+// Pin pins the message and stores the system message announcing it in one
+// transaction. It reports false and writes nothing when the message is
+// already pinned.
+func (r *chatRepository) Pin(pin *entity.PinnedMessage, notice *entity.Message) (bool, error) {
+	pinned := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(pin)
+		if result.Error != nil {
+			return result.Error
 		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		pinned = true
+		return createNotice(tx, notice)
+	})
+	if err != nil {
+		return false, err
 	}
+	return pinned, nil
+}
 
-	var filteredChats []entity.Chat
-	if search != "" {
-		for i := range allChats {
-			matchesSearch := false
-			if allChats[i].User.ID > 0 && allChats[i].User.FullName != "" {
-				matchesSearch = r.db.Where("id = ? AND full_name ILIKE ?", allChats[i].User.ID, "%"+search+"%").First(&entity.User{}).Error == nil
-			}
-			if !matchesSearch && allChats[i].LastMessageText != nil {
-				text := *allChats[i].LastMessageText
-				var matches bool
-				r.db.Raw("SELECT ? ILIKE ?", text, "%"+search+"%").Scan(&matches)
-				matchesSearch = matches
-			}
-			if matchesSearch {
-				filteredChats = append(filteredChats, allChats[i])
-			}
+// Unpin is the counterpart of Pin, reporting false when the message is not
+// pinned.
+func (r *chatRepository) Unpin(chatID uint, messageID uint, notice *entity.Message) (bool, error) {
+	unpinned := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where(&entity.PinnedMessage{ChatID: chatID, MessageID: messageID}).
+			Delete(&entity.PinnedMessage{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
 		}
-	} else {
-		filteredChats = allChats
+
+		unpinned = true
+		return createNotice(tx, notice)
+	})
+	if err != nil {
+		return false, err
+	}
+	return unpinned, nil
+}
+
+// createNotice stores a system message as the chat's latest one, read by its
+// author.
+func createNotice(tx *gorm.DB, notice *entity.Message) error {
+	if err := tx.Create(notice).Error; err != nil {
+		return err
+	}
+
+	if err := updateLastMessage(tx, notice.ChatID, notice); err != nil {
+		return err
 	}
 
-	for i := 0; i < len(filteredChats)-1; i++ {
-		for j := i + 1; j < len(filteredChats); j++ {
-			if filteredChats[i].UpdatedAt.Before(filteredChats[j].UpdatedAt) ||
-				(filteredChats[i].UpdatedAt.Equal(filteredChats[j].UpdatedAt) && filteredChats[i].ID < filteredChats[j].ID) {
-				filteredChats[i], filteredChats[j] = filteredChats[j], filteredChats[i]
+	_, err := markRead(tx, notice.ChatID, notice.AuthorID, notice.ID)
+	return err
+}
+
+func (r *chatRepository) Create(chat *entity.Chat) error {
+	return r.db.Create(chat).Error
+}
+
+func (r *chatRepository) Update(chat *entity.Chat) error {
+	return r.db.Save(chat).Error
+}
+
+// Delete removes the chat with its members, messages and everything attached
+// to them, and returns the deleted attachments so their files can be removed.
+func (r *chatRepository) Delete(chatID uint) ([]entity.Attachment, error) {
+	var attachments []entity.Attachment
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		messageIDs := tx.Model(&entity.Message{}).Select("id").Where("chat_id = ?", chatID)
+
+		if err := tx.Model(&entity.Chat{ID: chatID}).UpdateColumn("last_message_id", nil).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("message_id IN (?)", messageIDs).Find(&attachments).Error; err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{
+			&entity.Attachment{},
+			&entity.MessageEdit{},
+			&entity.MessageReaction{},
+			&entity.HiddenMessage{},
+		} {
+			if err := tx.Where("message_id IN (?)", messageIDs).Delete(model).Error; err != nil {
+				return err
 			}
 		}
+
+		for _, model := range []interface{}{
+			&entity.PinnedMessage{},
+			&entity.Message{},
+			&entity.ChatMember{},
+		} {
+			if err := tx.Where("chat_id = ?", chatID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Delete(&entity.Chat{}, chatID).Error
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	var cursorID uint
-	if nextToken != "" {
-		var err error
-		cursorID, err = pagination.DecodeToken(nextToken)
-		if err != nil {
-			cursorID = 0
+	return attachments, nil
+}
+
+// hideHiddenLastMessages replaces the preview of chats whose last message the
+// user deleted for themselves with the latest message they can still see.
+func (r *chatRepository) hideHiddenLastMessages(chats []entity.Chat, userID uint) error {
+	var lastMessageIDs []uint
+	for _, chat := range chats {
+		if chat.LastMessageID != nil {
+			lastMessageIDs = append(lastMessageIDs, *chat.LastMessageID)
 		}
 	}
+	if len(lastMessageIDs) == 0 {
+		return nil
+	}
 
-	var startIdx int
-	if cursorID > 0 {
-		for i, chat := range filteredChats {
-			if chat.ID == cursorID {
-				startIdx = i + 1
-				break
-			}
+	var hiddenIDs []uint
+	if err := r.db.Model(&entity.HiddenMessage{}).
+		Where("user_id = ? AND message_id IN ?", userID, lastMessageIDs).
+		Pluck("message_id", &hiddenIDs).Error; err != nil {
+		return err
+	}
+	if len(hiddenIDs) == 0 {
+		return nil
+	}
+
+	hidden := make(map[uint]bool, len(hiddenIDs))
+	for _, id := range hiddenIDs {
+		hidden[id] = true
+	}
+
+	var chatIDs []uint
+	for _, chat := range chats {
+		if chat.LastMessageID != nil && hidden[*chat.LastMessageID] {
+			chatIDs = append(chatIDs, chat.ID)
 		}
 	}
 
-	endIdx := startIdx + limit + 1
-	if endIdx > len(filteredChats) {
-		endIdx = len(filteredChats)
+	var previews []entity.Message
+	if err := r.db.Preload("Author").
+		Where("id IN (?)", r.db.Raw(`
+			SELECT DISTINCT ON (chat_id) id
+			FROM messages
+			WHERE chat_id IN ?
+				AND thread_root_id IS NULL
+				AND deleted_at IS NULL
+				AND NOT EXISTS (
+					SELECT 1 FROM hidden_messages
+					WHERE hidden_messages.message_id = messages.id AND hidden_messages.user_id = ?
+				)
+			ORDER BY chat_id, created_at DESC, id DESC
+		`, chatIDs, userID)).
+		Find(&previews).Error; err != nil {
+		return err
 	}
 
-	chats := filteredChats[startIdx:endIdx]
+	previewByChat := make(map[uint]*entity.Message, len(previews))
+	for i := range previews {
+		previewByChat[previews[i].ChatID] = &previews[i]
+	}
 
-	var hasNext bool
-	if len(chats) > limit {
-		hasNext = true
-		chats = chats[:limit]
+	for i := range chats {
+		if chats[i].LastMessageID == nil || !hidden[*chats[i].LastMessageID] {
+			continue
+		}
+
+		preview := previewByChat[chats[i].ID]
+		chats[i].LastMessage = preview
+		if preview == nil {
+			chats[i].LastMessageID = nil
+			chats[i].LastMessageText = nil
+			continue
+		}
+		chats[i].LastMessageID = &preview.ID
+		chats[i].LastMessageText = &preview.Text
+	}
+
+	return nil
+}
+
+func (r *chatRepository) attachPeers(chats []entity.Chat, userID uint) error {
+	if len(chats) == 0 {
+		return nil
 	}
 
 	chatIDs := make([]uint, len(chats))
@@ -175,110 +547,149 @@ func (r *chatRepository) GetByUserID(userID uint, limit int, nextToken string, s
 		chatIDs[i] = chats[i].ID
 	}
 
-	if len(chatIDs) > 0 {
-		var chatsWithMessages []entity.Chat
-		r.db.Where("id IN ?", chatIDs).
-			Preload("LastMessage.Author").
-			Find(&chatsWithMessages)
+	var peers []entity.ChatMember
+	err := r.db.Select("chat_members.*").
+		Preload("User").
+		Joins("JOIN chats ON chats.id = chat_members.chat_id AND chats.type = ?", entity.ChatTypeDirect).
+		Where("chat_members.chat_id IN ? AND chat_members.user_id <> ?", chatIDs, userID).
+		Order("chat_members.joined_at, chat_members.user_id").
+		Find(&peers).Error
+	if err != nil {
+		return err
+	}
 
-		chatsMap := make(map[uint]*entity.Chat)
-		for i := range chats {
-			chatsMap[chats[i].ID] = &chats[i]
-		}
-
-		for i := range chatsWithMessages {
-			if chat, exists := chatsMap[chatsWithMessages[i].ID]; exists {
-				chat.LastMessage = chatsWithMessages[i].LastMessage
-				if chat.LastMessage != nil {
-					chat.LastMessage.Chat = nil
-				}
-			}
+	peerMap := make(map[uint]*entity.User)
+	for i := range peers {
+		if _, exists := peerMap[peers[i].ChatID]; !exists {
+			peerMap[peers[i].ChatID] = &peers[i].User
 		}
 	}
 
-	var token string
-	if hasNext && len(chats) > 0 {
-		lastChat := chats[len(chats)-1]
-		token = pagination.EncodeToken(lastChat.ID)
+	for i := range chats {
+		chats[i].User = peerMap[chats[i].ID]
 	}
 
-	return chats, token, nil
+	return nil
 }
 
-func (r *chatRepository) GetByID(id uint) (*entity.Chat, error) {
-	var chat entity.Chat
-	err := r.db.Preload("LastMessage.Author").First(&chat, id).Error
-	if err != nil {
-		return nil, err
+func (r *chatRepository) attachReadState(chats []entity.Chat, userID uint) error {
+	if len(chats) == 0 {
+		return nil
 	}
 
-	var otherUser struct {
-		UserID uint `gorm:"column:user_id"`
+	chatIDs := make([]uint, len(chats))
+	for i := range chats {
+		chatIDs[i] = chats[i].ID
 	}
 
-	r.db.Raw(`
-		SELECT messages.author_id as user_id
-		FROM messages
-		WHERE messages.chat_id = ?
-			AND messages.author_id != ?
-		ORDER BY messages.created_at DESC
-		LIMIT 1
-	`, id, chat.UserID).Scan(&otherUser)
+	var readStates []struct {
+		ChatID                       uint  `gorm:"column:chat_id"`
+		LastReadMessageID            *uint `gorm:"column:last_read_message_id"`
+		OthersLastReadMessageID      *uint `gorm:"column:others_last_read_message_id"`
+		OthersLastDeliveredMessageID *uint `gorm:"column:others_last_delivered_message_id"`
+		UnreadCount                  int   `gorm:"column:unread_count"`
+	}
 
-	if otherUser.UserID > 0 {
-		var user entity.User
-		if err := r.db.First(&user, otherUser.UserID).Error; err == nil {
-			chat.User = user
-		}
+	err := r.db.Raw(`
+		SELECT
+			me.chat_id,
+			me.last_read_message_id,
+			others.last_read_message_id AS others_last_read_message_id,
+			others.last_delivered_message_id AS others_last_delivered_message_id,
+			(
+				SELECT COUNT(*)
+				FROM messages
+				WHERE messages.chat_id = me.chat_id
+					AND messages.id > COALESCE(me.last_read_message_id, 0)
+					AND messages.author_id <> me.user_id
+					AND messages.deleted_at IS NULL
+					AND messages.thread_root_id IS NULL
+					AND NOT EXISTS (
+						SELECT 1 FROM hidden_messages
+						WHERE hidden_messages.message_id = messages.id AND hidden_messages.user_id = me.user_id
+					)
+			) AS unread_count
+		FROM chat_members me
+		LEFT JOIN LATERAL (
+			SELECT
+				MAX(chat_members.last_read_message_id) AS last_read_message_id,
+				MAX(chat_members.last_delivered_message_id) AS last_delivered_message_id
+			FROM chat_members
+			WHERE chat_members.chat_id = me.chat_id AND chat_members.user_id <> me.user_id
+		) others ON TRUE
+		WHERE me.chat_id IN ? AND me.user_id = ?
+	`, chatIDs, userID).Scan(&readStates).Error
+	if err != nil {
+		return err
+	}
 
-		var unreadCount int64
-		r.db.Model(&entity.Message{}).
-			Where("chat_id = ? AND author_id = ? AND is_read = ?", id, otherUser.UserID, false).
-			Count(&unreadCount)
-		chat.UnreadCount = int(unreadCount)
-	} else {
-		chat.UnreadCount = 0
+	readStateMap := make(map[uint]int, len(readStates))
+	for i, readState := range readStates {
+		readStateMap[readState.ChatID] = i
 	}
 
-	if chat.LastMessage != nil {
-		chat.LastMessage.Chat = nil
+	for i := range chats {
+		index, ok := readStateMap[chats[i].ID]
+		if !ok {
+			continue
+		}
+		chats[i].LastReadMessageID = readStates[index].LastReadMessageID
+		chats[i].OthersLastReadMessageID = readStates[index].OthersLastReadMessageID
+		chats[i].OthersLastDeliveredMessageID = readStates[index].OthersLastDeliveredMessageID
+		chats[i].UnreadCount = readStates[index].UnreadCount
 	}
-	return &chat, nil
+
+	return nil
 }
 
-func (r *chatRepository) FindOrCreateChatByUsers(senderID uint, recipientID uint) (*entity.Chat, error) {
-	var chat entity.Chat
+func (r *chatRepository) attachPinnedMessages(chats []entity.Chat) error {
+	if len(chats) == 0 {
+		return nil
+	}
 
-	err := r.db.Where("chats.user_id = ?", senderID).
-		Joins("JOIN messages ON messages.chat_id = chats.id").
-		Where("messages.author_id = ? OR messages.author_id = ?", senderID, recipientID).
-		Group("chats.id").
-		First(&chat).Error
+	chatIDs := make([]uint, len(chats))
+	for i := range chats {
+		chatIDs[i] = chats[i].ID
+	}
 
-	if err == nil {
-		return &chat, nil
+	var pins []entity.PinnedMessage
+	err := r.db.Raw(`
+		SELECT DISTINCT ON (pinned_messages.chat_id) pinned_messages.*
+		FROM pinned_messages
+		WHERE pinned_messages.chat_id IN ?
+		ORDER BY pinned_messages.chat_id, pinned_messages.created_at DESC, pinned_messages.id DESC
+	`, chatIDs).Scan(&pins).Error
+	if err != nil {
+		return err
 	}
 
-	if err != gorm.ErrRecordNotFound {
-		return nil, err
+	if len(pins) == 0 {
+		return nil
 	}
 
-	newChat := entity.Chat{
-		UserID:      senderID,
-		UnreadCount: 0,
+	messageIDs := make([]uint, len(pins))
+	for i := range pins {
+		messageIDs[i] = pins[i].MessageID
 	}
 
-	if err := r.db.Create(&newChat).Error; err != nil {
-		return nil, err
+	var messages []entity.Message
+	if err := r.db.Preload("Author").Where("id IN ?", messageIDs).Find(&messages).Error; err != nil {
+		return err
 	}
 
-	return &newChat, nil
-}
+	messageMap := make(map[uint]*entity.Message)
+	for i := range messages {
+		messageMap[messages[i].ID] = &messages[i]
+	}
 
-func (r *chatRepository) Create(chat *entity.Chat) error {
-	return r.db.Create(chat).Error
-}
+	pinnedMap := make(map[uint]*entity.Message)
+	for _, pin := range pins {
+		pinnedMap[pin.ChatID] = messageMap[pin.MessageID]
+	}
 
-func (r *chatRepository) Update(chat *entity.Chat) error {
-	return r.db.Save(chat).Error
+	for i := range chats {
+		chats[i].PinnedMessage = pinnedMap[chats[i].ID]
+	}
+
+	return nil
 }
@@ -1,11 +1,16 @@
 package repository
 
 import (
+	"errors"
+	"time"
+
 	"gin-real-time-talk/internal/entity"
 	"gin-real-time-talk/internal/entity/interfaces"
 	"gin-real-time-talk/pkg/pagination"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type messageRepository struct {
@@ -18,12 +23,261 @@ func NewMessageRepository(db *gorm.DB) interfaces.MessageRepository {
 	}
 }
 
-func (r *messageRepository) GetByChatID(chatID uint, limit int, nextToken string) ([]entity.Message, string, error) {
+func (r *messageRepository) GetByChatID(chatID uint, userID uint, limit int, nextToken string) ([]entity.Message, string, error) {
+	query := r.db.Where(&entity.Message{ChatID: chatID}).
+		Where("messages.thread_root_id IS NULL")
+
+	return r.paginate(query, userID, limit, nextToken)
+}
+
+func (r *messageRepository) GetThreadReplies(rootID uint, userID uint, limit int, nextToken string) ([]entity.Message, string, error) {
+	query := r.db.Where("messages.thread_root_id = ?", rootID)
+
+	return r.paginate(query, userID, limit, nextToken)
+}
+
+func (r *messageRepository) GetByID(id uint) (*entity.Message, error) {
+	var message entity.Message
+	err := r.db.Preload("Author").
+		Preload("ForwardedFrom").
+		Preload("Attachments").
+		First(&message, id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []entity.Message{message}
+	if err := r.attachReplyPreviews(messages); err != nil {
+		return nil, err
+	}
+
+	return &messages[0], nil
+}
+
+func (r *messageRepository) GetByClientMessageID(authorID uint, clientMessageID string) (*entity.Message, error) {
+	var message entity.Message
+	err := r.db.Where(&entity.Message{AuthorID: authorID, ClientMessageID: &clientMessageID}).
+		Select("id").
+		First(&message).Error
+	if err != nil {
+		return nil, err
+	}
+	return r.GetByID(message.ID)
+}
+
+func (r *messageRepository) Create(message *entity.Message) error {
+	attachments := message.Attachments
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Attachments").Create(message).Error; err != nil {
+			if isUniqueViolation(err, "idx_messages_author_client_message_id") {
+				return interfaces.ErrClientMessageIDTaken
+			}
+			return err
+		}
+
+		if err := r.linkAttachments(tx, message, attachments); err != nil {
+			return err
+		}
+
+		if message.ThreadRootID == nil {
+			return nil
+		}
+
+		return tx.Model(&entity.Message{ID: *message.ThreadRootID}).UpdateColumns(map[string]interface{}{
+			"thread_reply_count":   gorm.Expr("thread_reply_count + 1"),
+			"last_thread_reply_at": message.CreatedAt,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	message.Attachments = attachments
+	return nil
+}
+
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
+
+func (r *messageRepository) Edit(message *entity.Message, text string) error {
+	editedAt := time.Now()
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		edit := entity.MessageEdit{
+			MessageID: message.ID,
+			Text:      message.Text,
+		}
+		if err := tx.Create(&edit).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&entity.Message{ID: message.ID}).Updates(map[string]interface{}{
+			"text":      text,
+			"edited_at": editedAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&entity.Chat{}).
+			Where("id = ? AND last_message_id = ?", message.ChatID, message.ID).
+			UpdateColumn("last_message_text", text).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	message.Text = text
+	message.EditedAt = &editedAt
+	return nil
+}
+
+func (r *messageRepository) Hide(messageID uint, userID uint) error {
+	hidden := entity.HiddenMessage{
+		MessageID: messageID,
+		UserID:    userID,
+	}
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&hidden).Error
+}
+
+func (r *messageRepository) DeleteForEveryone(message *entity.Message) error {
+	deletedAt := time.Now()
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&entity.Message{ID: message.ID}).Updates(map[string]interface{}{
+			"text":       "",
+			"deleted_at": deletedAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where(&entity.MessageEdit{MessageID: message.ID}).Delete(&entity.MessageEdit{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where(&entity.MessageReaction{MessageID: message.ID}).Delete(&entity.MessageReaction{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where(&entity.PinnedMessage{MessageID: message.ID}).Delete(&entity.PinnedMessage{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("message_id = ?", message.ID).Delete(&entity.Attachment{}).Error; err != nil {
+			return err
+		}
+
+		if message.ThreadRootID != nil {
+			return tx.Model(&entity.Message{ID: *message.ThreadRootID}).UpdateColumns(map[string]interface{}{
+				"thread_reply_count": gorm.Expr("GREATEST(thread_reply_count - 1, 0)"),
+				"last_thread_reply_at": gorm.Expr(
+					"(SELECT MAX(created_at) FROM messages WHERE thread_root_id = ? AND deleted_at IS NULL)",
+					*message.ThreadRootID,
+				),
+			}).Error
+		}
+
+		var chat entity.Chat
+		if err := tx.First(&chat, message.ChatID).Error; err != nil {
+			return err
+		}
+
+		if chat.LastMessageID == nil || *chat.LastMessageID != message.ID {
+			return nil
+		}
+
+		var previous entity.Message
+		err := tx.Where("chat_id = ? AND deleted_at IS NULL AND thread_root_id IS NULL", message.ChatID).
+			Order("created_at DESC, id DESC").
+			First(&previous).Error
+
+		if err == gorm.ErrRecordNotFound {
+			return tx.Model(&chat).UpdateColumns(map[string]interface{}{
+				"last_message_id":   nil,
+				"last_message_text": nil,
+			}).Error
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&chat).UpdateColumns(map[string]interface{}{
+			"last_message_id":   previous.ID,
+			"last_message_text": previous.Text,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	message.Text = ""
+	message.DeletedAt = &deletedAt
+	message.Attachments = []entity.Attachment{}
+	return nil
+}
+
+func (r *messageRepository) attachReplyPreviews(messages []entity.Message) error {
+	var replyToIDs []uint
+	for i := range messages {
+		if messages[i].ReplyToID != nil {
+			replyToIDs = append(replyToIDs, *messages[i].ReplyToID)
+		}
+	}
+
+	if len(replyToIDs) == 0 {
+		return nil
+	}
+
+	var replies []entity.Message
+	if err := r.db.Preload("Author").Where("id IN ?", replyToIDs).Find(&replies).Error; err != nil {
+		return err
+	}
+
+	previewMap := make(map[uint]*entity.MessagePreview)
+	for i := range replies {
+		previewMap[replies[i].ID] = entity.NewMessagePreview(&replies[i])
+	}
+
+	for i := range messages {
+		if messages[i].ReplyToID != nil {
+			messages[i].ReplyTo = previewMap[*messages[i].ReplyToID]
+		}
+	}
+
+	return nil
+}
+
+func (r *messageRepository) GetReactions(messageID uint) ([]entity.MessageReaction, error) {
+	var reactions []entity.MessageReaction
+	err := r.db.Where(&entity.MessageReaction{MessageID: messageID}).
+		Order("created_at, id").
+		Find(&reactions).Error
+	if err != nil {
+		return nil, err
+	}
+	return reactions, nil
+}
+
+func (r *messageRepository) AddReaction(reaction *entity.MessageReaction) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(reaction).Error
+}
+
+func (r *messageRepository) RemoveReaction(messageID uint, userID uint, emoji string) error {
+	return r.db.Where(&entity.MessageReaction{MessageID: messageID, UserID: userID, Emoji: emoji}).
+		Delete(&entity.MessageReaction{}).Error
+}
+
+func (r *messageRepository) paginate(query *gorm.DB, userID uint, limit int, nextToken string) ([]entity.Message, string, error) {
 	limit = pagination.NormalizeLimit(limit)
 
-	query := r.db.Where(&entity.Message{ChatID: chatID}).
+	query = query.
+		Where("NOT EXISTS (SELECT 1 FROM hidden_messages WHERE hidden_messages.message_id = messages.id AND hidden_messages.user_id = ?)", userID).
 		Preload("Author").
-		Preload("Chat.User").
+		Preload("ForwardedFrom").
+		Preload("Attachments").
 		Order("messages.created_at DESC, messages.id DESC")
 
 	if nextToken != "" {
@@ -49,6 +303,14 @@ func (r *messageRepository) GetByChatID(chatID uint, limit int, nextToken string
 		messages = messages[:limit]
 	}
 
+	if err := r.attachReplyPreviews(messages); err != nil {
+		return nil, "", err
+	}
+
+	if err := r.attachReactions(messages, userID); err != nil {
+		return nil, "", err
+	}
+
 	var token string
 	if hasNext && len(messages) > 0 {
 		lastMessage := messages[len(messages)-1]
@@ -58,15 +320,68 @@ func (r *messageRepository) GetByChatID(chatID uint, limit int, nextToken string
 	return messages, token, nil
 }
 
-func (r *messageRepository) GetByID(id uint) (*entity.Message, error) {
-	var message entity.Message
-	err := r.db.Preload("Author").Preload("Chat.User").First(&message, id).Error
+func (r *messageRepository) attachReactions(messages []entity.Message, viewerID uint) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	messageIDs := make([]uint, len(messages))
+	for i := range messages {
+		messageIDs[i] = messages[i].ID
+	}
+
+	var reactions []entity.MessageReaction
+	err := r.db.Where("message_id IN ?", messageIDs).
+		Order("created_at, id").
+		Find(&reactions).Error
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	reactionMap := make(map[uint][]entity.MessageReaction)
+	for _, reaction := range reactions {
+		reactionMap[reaction.MessageID] = append(reactionMap[reaction.MessageID], reaction)
 	}
-	return &message, nil
+
+	for i := range messages {
+		messages[i].Reactions = entity.SummarizeReactions(reactionMap[messages[i].ID], viewerID)
+	}
+
+	return nil
 }
 
-func (r *messageRepository) Create(message *entity.Message) error {
-	return r.db.Create(message).Error
+func (r *messageRepository) linkAttachments(tx *gorm.DB, message *entity.Message, attachments []entity.Attachment) error {
+	var existingIDs []uint
+	for i := range attachments {
+		if attachments[i].ID != 0 {
+			existingIDs = append(existingIDs, attachments[i].ID)
+			continue
+		}
+
+		attachments[i].MessageID = &message.ID
+		if err := tx.Create(&attachments[i]).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(existingIDs) == 0 {
+		return nil
+	}
+
+	result := tx.Model(&entity.Attachment{}).
+		Where("id IN ? AND uploader_id = ? AND message_id IS NULL", existingIDs, message.AuthorID).
+		Update("message_id", message.ID)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected != int64(len(existingIDs)) {
+		return errors.New("some attachments are not available")
+	}
+
+	for i := range attachments {
+		attachments[i].MessageID = &message.ID
+	}
+
+	return nil
 }
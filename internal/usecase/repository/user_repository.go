@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"time"
+
 	"gin-real-time-talk/internal/entity"
 	"gin-real-time-talk/internal/entity/interfaces"
 
@@ -39,6 +41,23 @@ func (r *userRepository) GetByID(id uint) (*entity.User, error) {
 	return &user, nil
 }
 
+func (r *userRepository) GetByIDs(ids []uint) ([]entity.User, error) {
+	var users []entity.User
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	err := r.db.Where("id IN ?", ids).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 func (r *userRepository) Update(user *entity.User) error {
 	return r.db.Save(user).Error
 }
+
+func (r *userRepository) UpdateLastSeen(userID uint, lastSeenAt time.Time) error {
+	return r.db.Model(&entity.User{ID: userID}).UpdateColumn("last_seen_at", lastSeenAt).Error
+}
@@ -26,6 +26,14 @@ func Port(port string) Option {
 	}
 }
 
+// Addr sets the full listen address, for servers that must not listen on
+// every interface.
+func Addr(addr string) Option {
+	return func(s *Server) {
+		s.server.Addr = addr
+	}
+}
+
 func New(handler http.Handler, opts ...Option) *Server {
 	s := &Server{
 		server: &http.Server{
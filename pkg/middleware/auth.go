@@ -11,21 +11,7 @@ import (
 
 func AuthMiddleware(authUsecase interfaces.AuthUsecase) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var token string
-
-		accessToken, err := c.Cookie("access_token")
-		if err == nil && accessToken != "" {
-			token = accessToken
-		} else {
-			authHeader := c.GetHeader("Authorization")
-			if authHeader != "" {
-				parts := strings.Split(authHeader, " ")
-				if len(parts) == 2 && parts[0] == "Bearer" {
-					token = parts[1]
-				}
-			}
-		}
-
+		token := AccessToken(c)
 		if token == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "access token required"})
 			c.Abort()
@@ -44,3 +30,22 @@ func AuthMiddleware(authUsecase interfaces.AuthUsecase) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AccessToken returns the access token from the access_token cookie or the
+// Authorization header, or an empty string when neither is set.
+func AccessToken(c *gin.Context) string {
+	accessToken, err := c.Cookie("access_token")
+	if err == nil && accessToken != "" {
+		return accessToken
+	}
+
+	authHeader := c.GetHeader("Authorization")
+	if authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) == 2 && parts[0] == "Bearer" {
+			return parts[1]
+		}
+	}
+
+	return ""
+}
@@ -1,19 +1,34 @@
 package middleware
 
 import (
+	"net/http"
+	"slices"
 	"time"
 
+	"gin-real-time-talk/config"
+
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 func CORSMiddleware() gin.HandlerFunc {
 	return cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000", "http://localhost:3001"},
+		AllowOrigins:     config.Env.CORS.AllowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Idempotency-Key"},
+		ExposeHeaders:    []string{"Content-Length", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	})
 }
+
+// OriginAllowed reports whether the request comes from one of the CORS allowed
+// origins. Requests without an Origin header are only allowed when
+// CORS_ALLOW_EMPTY_ORIGIN is set.
+func OriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return config.Env.CORS.AllowEmptyOrigin
+	}
+	return slices.Contains(config.Env.CORS.AllowedOrigins, origin)
+}
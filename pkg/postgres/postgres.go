@@ -12,8 +12,8 @@ import (
 
 var DB *gorm.DB
 
-func New() (*gorm.DB, error) {
-	dsn := fmt.Sprintf(
+func DSN() string {
+	return fmt.Sprintf(
 		"host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
 		config.Env.DB.Host,
 		config.Env.DB.User,
@@ -22,6 +22,10 @@ func New() (*gorm.DB, error) {
 		config.Env.DB.Port,
 		config.Env.DB.SSLMode,
 	)
+}
+
+func New() (*gorm.DB, error) {
+	dsn := DSN()
 
 	var logLevel logger.LogLevel
 	if config.Env.App.Environment == "production" {
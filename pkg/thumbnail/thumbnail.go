@@ -0,0 +1,96 @@
+package thumbnail
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+const (
+	maxPixels   = 24_000_000
+	jpegQuality = 80
+)
+
+var ErrTooLarge = errors.New("image dimensions are too large")
+
+// Decode reads an image and converts it to RGBA once, so that resizing it to
+// several sizes works on the pixel buffer directly.
+func Decode(r io.ReadSeeker) (*image.RGBA, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image header: %w", err)
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba, nil
+	}
+
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba, nil
+}
+
+// Resize scales src down to width x height by averaging the source pixels
+// that fall into each destination pixel.
+func Resize(src *image.RGBA, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := max((y+1)*srcHeight/height, y0+1)
+
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := max((x+1)*srcWidth/width, x0+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[src.PixOffset(bounds.Min.X+x0, bounds.Min.Y+sy):]
+				for i := 0; i < (x1-x0)*4; i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					b += uint64(row[i+2])
+					a += uint64(row[i+3])
+				}
+				n += uint64(x1 - x0)
+			}
+
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8(r / n)
+			dst.Pix[offset+1] = uint8(g / n)
+			dst.Pix[offset+2] = uint8(b / n)
+			dst.Pix[offset+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+func EncodeJPEG(w io.Writer, img image.Image) error {
+	background := image.NewRGBA(img.Bounds())
+	draw.Draw(background, background.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(background, background.Bounds(), img, img.Bounds().Min, draw.Over)
+
+	return jpeg.Encode(w, background, &jpeg.Options{Quality: jpegQuality})
+}
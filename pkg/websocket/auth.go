@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// CloseTokenExpired closes a connection whose access token lapsed without
+	// a reauth frame.
+	CloseTokenExpired = 4001
+	// CloseAuthenticationFailed closes a connection that did not send a valid
+	// auth frame in time, or whose user can no longer log in. Clients should
+	// not retry with a refreshed token.
+	CloseAuthenticationFailed = 4003
+)
+
+// AccessTokenProtocol is offered by browser clients together with the token as
+// a second Sec-WebSocket-Protocol value, since they cannot set headers.
+const AccessTokenProtocol = "access_token"
+
+const (
+	authFrameWait = 10 * time.Second
+	// authFrameMaxSize bounds what an unauthenticated client can make the
+	// server buffer; a token fits easily.
+	authFrameMaxSize = 4 * 1024
+)
+
+type AuthCommandData struct {
+	Token string `json:"token"`
+}
+
+// ProtocolAccessToken returns the token passed as
+// "Sec-WebSocket-Protocol: access_token, <token>", or an empty string.
+func ProtocolAccessToken(r *http.Request) string {
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == AccessTokenProtocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+// ReadAuthToken waits for the auth frame a client sends first when it had no
+// way to pass a token with the upgrade request. Until it arrives frames are
+// capped at authFrameMaxSize; the usual limit applies once it has been read.
+func ReadAuthToken(conn *websocket.Conn) (string, error) {
+	conn.SetReadLimit(authFrameMaxSize)
+	conn.SetReadDeadline(time.Now().Add(authFrameWait))
+	defer conn.SetReadDeadline(time.Time{})
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return "", err
+	}
+	conn.SetReadLimit(maxMessageSize)
+
+	command, err := codecForProtocol(conn.Subprotocol()).DecodeCommand(data)
+	if err != nil || command.Type != CommandAuth {
+		return "", errors.New("expected auth frame")
+	}
+
+	var auth AuthCommandData
+	if err := command.DecodeData(&auth); err != nil || strings.TrimSpace(auth.Token) == "" {
+		return "", errors.New("token is required")
+	}
+
+	return auth.Token, nil
+}
+
+// CloseWithCode sends a close frame and closes the connection.
+func CloseWithCode(conn *websocket.Conn, code int, reason string) {
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+	conn.Close()
+}
@@ -0,0 +1,44 @@
+package websocket
+
+// Event is a broadcast as it travels through a Backplane. A nil UserIDs means
+// every connected user. Seqs holds the sequence number the event got in each
+// recipient's stream.
+type Event struct {
+	UserIDs     []uint          `json:"userIds,omitempty"`
+	Seqs        map[uint]uint64 `json:"seqs,omitempty"`
+	Message     *Message        `json:"message"`
+	Ephemeral   bool            `json:"ephemeral,omitempty"`
+	CoalesceKey string          `json:"coalesceKey,omitempty"`
+}
+
+// Backplane fans broadcasts out to every hub in the cluster. Each hub
+// subscribes once and delivers received events to its local clients only.
+// Every user has their own stream of sequence numbers, which increase by one
+// with each non-ephemeral event sent to them; the backplane assigns them so
+// that all nodes agree. Events to every user and ephemeral events are not
+// sequenced.
+//
+// The backplane also counts every node's connections, so that presence is the
+// same whichever node is asked.
+type Backplane interface {
+	// Subscribe starts passing published events to deliver, in the order
+	// their sequence numbers were assigned. dropped is called with the users
+	// whose connections were discarded because their node stopped responding,
+	// and lost when events may have been missed without knowing whose.
+	Subscribe(deliver func(event *Event), dropped func(userIDs []uint), lost func()) error
+	Publish(event *Event) error
+	// Seq returns the last sequence number assigned in the user's stream.
+	Seq(userID uint) (uint64, error)
+	// Connect and Disconnect count a connection of the user on this node and
+	// return how many the user has across the cluster afterwards.
+	Connect(userID uint) (int, error)
+	Disconnect(userID uint) (int, error)
+	// Online returns which of the users have a connection on any node.
+	Online(userIDs []uint) (map[uint]bool, error)
+	// Acquire counts a connection under key on this node unless the cluster
+	// already has limit of them, and reports whether it did. A limit of 0
+	// always counts it. Release uncounts one.
+	Acquire(key string, limit int) (bool, error)
+	Release(key string) error
+	Close() error
+}
@@ -1,7 +1,6 @@
 package websocket
 
 import (
-	"encoding/json"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -14,25 +13,50 @@ const (
 	maxMessageSize = 512 * 1024
 )
 
+// Client is one connection of an authenticated user. It is closed with
+// CloseTokenExpired once expiresAt passes, unless a reauth frame extends it,
+// or with CloseAuthenticationFailed if the user fails the hub's CheckUser by
+// then; a zero expiresAt never expires.
 type Client struct {
-	hub    *Hub
-	conn   *websocket.Conn
-	send   chan *Message
-	userID uint
+	hub       *Hub
+	conn      *websocket.Conn
+	send      *sendQueue
+	userID    uint
+	since     uint64
+	seq       uint64
+	codec     Codec
+	expiresAt chan time.Time
 }
 
-func NewClient(hub *Hub, conn *websocket.Conn, userID uint) *Client {
-	return &Client{
-		hub:    hub,
-		conn:   conn,
-		send:   make(chan *Message, 256),
-		userID: userID,
+func NewClient(hub *Hub, conn *websocket.Conn, userID uint, since uint64, expiresAt time.Time) *Client {
+	c := &Client{
+		hub:       hub,
+		conn:      conn,
+		send:      newSendQueue(hub.sendQueueSize, hub.policies),
+		userID:    userID,
+		since:     since,
+		codec:     jsonCodec{},
+		expiresAt: make(chan time.Time, 1),
 	}
+	if conn != nil {
+		c.codec = codecForProtocol(conn.Subprotocol())
+	}
+	c.expiresAt <- expiresAt
+	return c
+}
+
+// Reauthenticate moves the client's expiry to that of a fresh token.
+func (c *Client) Reauthenticate(expiresAt time.Time) {
+	select {
+	case <-c.expiresAt:
+	default:
+	}
+	c.expiresAt <- expiresAt
 }
 
 func (c *Client) ReadPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.Unregister(c)
 		c.conn.Close()
 	}()
 
@@ -44,58 +68,93 @@ func (c *Client) ReadPump() {
 	})
 
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 			}
 			break
 		}
+
+		command, err := c.codec.DecodeCommand(data)
+		if err != nil || command.Type == "" {
+			continue
+		}
+
+		c.hub.dispatch(c, command)
 	}
 }
 
+func (c *Client) UserID() uint {
+	return c.userID
+}
+
 func (c *Client) WritePump() {
 	ticker := time.NewTicker(pingPeriod)
+	expiry := time.NewTimer(0)
+	expiry.Stop()
 	defer func() {
 		ticker.Stop()
+		expiry.Stop()
 		c.conn.Close()
 	}()
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+		case expiresAt := <-c.expiresAt:
+			expiry.Stop()
+			if !expiresAt.IsZero() {
+				expiry.Reset(time.Until(expiresAt))
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+		case <-expiry.C:
+			code, reason := c.hub.expiryClose(c.userID)
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+			return
+
+		case <-c.send.ready:
+			frames, closed, code, reason := c.send.drain()
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if closed {
+				var data []byte
+				if code != 0 {
+					data = websocket.FormatCloseMessage(code, reason)
+				}
+				c.conn.WriteMessage(websocket.CloseMessage, data)
 				return
 			}
+			if len(frames) == 0 {
+				continue
+			}
 
-			jsonData, err := json.Marshal(message)
+			w, err := c.conn.NextWriter(c.codec.FrameType())
 			if err != nil {
 				return
 			}
 
-			w.Write(jsonData)
-
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				msg := <-c.send
-				jsonData, err := json.Marshal(msg)
+			written := make([]*Message, 0, len(frames))
+			for _, f := range frames {
+				data, err := f.encode(c.codec)
 				if err != nil {
 					continue
 				}
-				w.Write(jsonData)
+				if len(written) > 0 {
+					w.Write(c.codec.Separator())
+				}
+				w.Write(data)
+				written = append(written, f.message)
 			}
 
 			if err := w.Close(); err != nil {
 				return
 			}
 
+			for _, msg := range written {
+				if msg.needsDeliveryReceipt(c.userID) {
+					c.hub.reportDelivered(c.userID, msg.Message.ChatID, msg.Message.ID)
+				}
+			}
+
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -0,0 +1,185 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	ProtocolJSON    = "rtt.json.v1"
+	ProtocolMsgpack = "rtt.msgpack.v1"
+)
+
+// Protocols lists the subprotocols a client can negotiate, in order of
+// preference. AccessTokenProtocol comes last so that it is only selected for
+// clients that offer no encoding; they get JSON.
+var Protocols = []string{ProtocolMsgpack, ProtocolJSON, AccessTokenProtocol}
+
+// Codec encodes frames for one subprotocol. Several encoded messages are
+// written into one frame separated by Separator. A message is encoded once for
+// all of its recipients; WithSeq then adds the seq field of one recipient's
+// stream to the encoded object.
+type Codec interface {
+	Protocol() string
+	FrameType() int
+	Separator() []byte
+	Encode(message *Message) ([]byte, error)
+	WithSeq(data []byte, seq uint64) ([]byte, error)
+	DecodeCommand(data []byte) (*Command, error)
+}
+
+func codecForProtocol(protocol string) Codec {
+	if protocol == ProtocolMsgpack {
+		return msgpackCodec{}
+	}
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Protocol() string  { return ProtocolJSON }
+func (jsonCodec) FrameType() int    { return websocket.TextMessage }
+func (jsonCodec) Separator() []byte { return []byte{'\n'} }
+
+func (jsonCodec) Encode(message *Message) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (jsonCodec) WithSeq(data []byte, seq uint64) ([]byte, error) {
+	if len(data) < 2 || data[0] != '{' {
+		return nil, errors.New("encoded message is not an object")
+	}
+
+	out := make([]byte, 0, len(data)+32)
+	out = append(out, `{"seq":`...)
+	out = strconv.AppendUint(out, seq, 10)
+	out = append(out, ',')
+	return append(out, data[1:]...), nil
+}
+
+func (jsonCodec) DecodeCommand(data []byte) (*Command, error) {
+	var command Command
+	if err := json.Unmarshal(data, &command); err != nil {
+		return nil, err
+	}
+	return &command, nil
+}
+
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	h.RawToString = true
+	h.WriteExt = true
+	return h
+}()
+
+// msgpackCodec writes msgpack values back to back in a binary frame. Commands
+// arrive as msgpack too; their data is converted to JSON so that handlers
+// decode it the same way for every protocol.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Protocol() string  { return ProtocolMsgpack }
+func (msgpackCodec) FrameType() int    { return websocket.BinaryMessage }
+func (msgpackCodec) Separator() []byte { return nil }
+
+func (msgpackCodec) Encode(message *Message) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := codec.NewEncoder(&buf, msgpackHandle).Encode(message); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WithSeq rewrites the map header with one more entry and puts seq first.
+func (msgpackCodec) WithSeq(data []byte, seq uint64) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("encoded message is empty")
+	}
+
+	var entries, header int
+	switch {
+	case data[0]&0xf0 == 0x80:
+		entries, header = int(data[0]&0x0f), 1
+	case data[0] == 0xde && len(data) >= 3:
+		entries, header = int(binary.BigEndian.Uint16(data[1:3])), 3
+	case data[0] == 0xdf && len(data) >= 5:
+		entries, header = int(binary.BigEndian.Uint32(data[1:5])), 5
+	default:
+		return nil, errors.New("encoded message is not a map")
+	}
+
+	var buf bytes.Buffer
+	switch entries++; {
+	case entries < 16:
+		buf.WriteByte(0x80 | byte(entries))
+	case entries <= 0xffff:
+		buf.WriteByte(0xde)
+		binary.Write(&buf, binary.BigEndian, uint16(entries))
+	default:
+		buf.WriteByte(0xdf)
+		binary.Write(&buf, binary.BigEndian, uint32(entries))
+	}
+
+	encoder := codec.NewEncoder(&buf, msgpackHandle)
+	if err := encoder.Encode("seq"); err != nil {
+		return nil, err
+	}
+	if err := encoder.Encode(seq); err != nil {
+		return nil, err
+	}
+	buf.Write(data[header:])
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) DecodeCommand(data []byte) (*Command, error) {
+	var raw struct {
+		Type     string      `codec:"type"`
+		ClientID string      `codec:"clientId"`
+		Data     interface{} `codec:"data"`
+	}
+	if err := codec.NewDecoderBytes(data, msgpackHandle).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	command := &Command{Type: raw.Type, ClientID: raw.ClientID}
+	if raw.Data != nil {
+		payload, err := json.Marshal(raw.Data)
+		if err != nil {
+			return nil, err
+		}
+		command.Data = payload
+	}
+	return command, nil
+}
+
+// encode returns the frame encoded for the codec, with its seq when it has
+// one.
+func (f frame) encode(c Codec) ([]byte, error) {
+	data, err := f.message.encode(c)
+	if err != nil || f.seq == 0 {
+		return data, err
+	}
+	return c.WithSeq(data, f.seq)
+}
+
+// encode returns the message encoded for the codec, encoding it only once per
+// codec however many clients it is sent to.
+func (m *Message) encode(c Codec) ([]byte, error) {
+	if data, ok := m.encodings.Load(c.Protocol()); ok {
+		return data.([]byte), nil
+	}
+
+	data, err := c.Encode(m)
+	if err != nil {
+		return nil, err
+	}
+	m.encodings.Store(c.Protocol(), data)
+	return data, nil
+}
@@ -0,0 +1,38 @@
+package websocket
+
+import "encoding/json"
+
+const (
+	CommandDelivered   = "delivered"
+	CommandTypingStart = "typing_start"
+	CommandTypingStop  = "typing_stop"
+	CommandSendMessage = "send_message"
+	CommandAuth        = "auth"
+	CommandReauth      = "reauth"
+)
+
+const (
+	ErrorCodeInvalidPayload   = "invalid_payload"
+	ErrorCodeValidationFailed = "validation_failed"
+	ErrorCodeRejected         = "rejected"
+)
+
+type Command struct {
+	Type     string          `json:"type"`
+	ClientID string          `json:"clientId,omitempty"`
+	Data     json.RawMessage `json:"data"`
+}
+
+type ChatCommandData struct {
+	ChatID    uint `json:"chatId"`
+	MessageID uint `json:"messageId,omitempty"`
+}
+
+type CommandHandler func(client *Client, command *Command)
+
+func (c *Command) DecodeData(v interface{}) error {
+	if len(c.Data) == 0 {
+		return json.Unmarshal([]byte("{}"), v)
+	}
+	return json.Unmarshal(c.Data, v)
+}
@@ -0,0 +1,71 @@
+package websocket
+
+import "time"
+
+const (
+	eventLogSize      = 256
+	eventLogRetention = 5 * time.Minute
+	eventLogSweep     = time.Minute
+)
+
+type loggedEvent struct {
+	frame    frame
+	loggedAt time.Time
+}
+
+// eventLog keeps the most recent events sent to one user so that a client can
+// resume after a dropped connection. evictedSeq is the highest sequence number
+// in the user's stream that is no longer available; resuming from anything
+// older needs a resync.
+type eventLog struct {
+	events     []loggedEvent
+	evictedSeq uint64
+}
+
+// newEventLog starts a log at the given frame, so only what came before it is
+// unavailable.
+func newEventLog(first frame) *eventLog {
+	return &eventLog{evictedSeq: first.seq - 1}
+}
+
+func (l *eventLog) append(f frame, now time.Time) {
+	if len(l.events) == eventLogSize {
+		l.evictedSeq = l.events[0].frame.seq
+		l.events = append(l.events[:0], l.events[1:]...)
+	}
+	l.events = append(l.events, loggedEvent{frame: f, loggedAt: now})
+}
+
+func (l *eventLog) expire(now time.Time) {
+	n := 0
+	for n < len(l.events) && now.Sub(l.events[n].loggedAt) > eventLogRetention {
+		l.evictedSeq = l.events[n].frame.seq
+		n++
+	}
+
+	if n > 0 {
+		l.events = append(l.events[:0], l.events[n:]...)
+	}
+}
+
+// lastSeq returns the sequence number of the newest event in the log.
+func (l *eventLog) lastSeq() uint64 {
+	if len(l.events) == 0 {
+		return l.evictedSeq
+	}
+	return l.events[len(l.events)-1].frame.seq
+}
+
+func (l *eventLog) since(seq uint64) ([]frame, bool) {
+	if seq < l.evictedSeq || seq > l.lastSeq() {
+		return nil, false
+	}
+
+	var frames []frame
+	for _, event := range l.events {
+		if event.frame.seq > seq {
+			frames = append(frames, event.frame)
+		}
+	}
+	return frames, true
+}
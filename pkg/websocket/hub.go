@@ -1,114 +1,495 @@
 package websocket
 
 import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
 
 	"gin-real-time-talk/internal/entity"
+	"gin-real-time-talk/pkg/logger"
 )
 
+const (
+	deliveryReceiptBufferSize = 1024
+	defaultShardInboxSize     = 4096
+)
+
+type DeliveryHandler func(userID uint, chatID uint, messageID uint)
+
+type PresenceHandler func(userID uint, online bool)
+
+// UserCheck reports an error when the user may no longer be connected.
+type UserCheck func(userID uint) error
+
+// OverflowPolicy decides what happens to an event when a shard's inbox is full.
+type OverflowPolicy int
+
+const (
+	// OverflowResync drops the event and makes its recipients on the shard
+	// resync over HTTP, since their event streams now have a hole in them.
+	// Ephemeral events are simply dropped.
+	OverflowResync OverflowPolicy = iota
+	// OverflowBlock makes the publisher wait for room in the inbox.
+	OverflowBlock
+)
+
+const slowestClientsInStats = 10
+
+type HubConfig struct {
+	Shards        int
+	InboxSize     int
+	Overflow      OverflowPolicy
+	SendQueueSize int
+	Policies      map[string]SlowConsumerPolicy
+}
+
+type HubStats struct {
+	Published      uint64        `json:"published"`
+	Dropped        uint64        `json:"dropped"`
+	Clients        int           `json:"clients"`
+	Queued         int           `json:"queued"`
+	SlowestClients []ClientStats `json:"slowestClients"`
+}
+
+// ClientStats describes the send queue of one connection. HighWater is the
+// deepest the queue has been since the client connected.
+type ClientStats struct {
+	UserID    uint   `json:"userId"`
+	Queued    int    `json:"queued"`
+	HighWater int    `json:"highWater"`
+	Dropped   uint64 `json:"dropped"`
+	Coalesced uint64 `json:"coalesced"`
+}
+
+// Hub tracks the connected clients of this node. Clients are sharded by user
+// ID; each shard owns its users' connections and event logs and is driven by
+// its own goroutine, so publishing never waits for delivery.
 type Hub struct {
-	clients    map[uint]map[*Client]bool
-	broadcast  chan *Message
-	register   chan *Client
-	unregister chan *Client
-	mu         sync.RWMutex
+	shards        []*shard
+	overflow      OverflowPolicy
+	sendQueueSize int
+	policies      map[string]SlowConsumerPolicy
+	delivered     chan deliveryReceipt
+	onDelivered   DeliveryHandler
+	onPresence    PresenceHandler
+	checkUser     UserCheck
+	handlers      map[string]CommandHandler
+	observers     sync.Map
+	backplane     Backplane
+	logger        *logger.Logger
+	published     atomic.Uint64
+	dropped       atomic.Uint64
+	done          chan struct{}
+	stopOnce      sync.Once
 }
 
+// Message is a frame sent to clients. Events broadcast to users are sent with
+// a seq field holding their sequence number in the recipient's stream;
+// ephemeral events such as typing indicators and frames addressed to a single
+// connection have none. Queued events with the same CoalesceKey replace each
+// other when their type uses SlowConsumerCoalesce. A Message must not be
+// modified once sent, since its encodings are cached.
 type Message struct {
 	Type        string          `json:"type"`
+	ClientID    string          `json:"clientId,omitempty"`
 	Data        interface{}     `json:"data"`
-	RecipientID uint            `json:"recipientId,omitempty"`
 	Message     *entity.Message `json:"message,omitempty"`
+	Ephemeral   bool            `json:"-"`
+	CoalesceKey string          `json:"-"`
+	encodings   sync.Map
+}
+
+// clone copies the message without its cached encodings.
+func (m *Message) clone() *Message {
+	return &Message{
+		Type:        m.Type,
+		ClientID:    m.ClientID,
+		Data:        m.Data,
+		Message:     m.Message,
+		Ephemeral:   m.Ephemeral,
+		CoalesceKey: m.CoalesceKey,
+	}
 }
 
-func NewHub() *Hub {
-	return &Hub{
-		clients:    make(map[uint]map[*Client]bool),
-		broadcast:  make(chan *Message),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+// DecodeData decodes the message's data into v the way a client would, so
+// that it works the same for messages published on this node and messages
+// that arrived from another one.
+func (m *Message) DecodeData(v interface{}) error {
+	data, err := json.Marshal(m.Data)
+	if err != nil {
+		return err
 	}
+	return json.Unmarshal(data, v)
+}
+
+type deliveryReceipt struct {
+	userID    uint
+	chatID    uint
+	messageID uint
+}
+
+func NewHub(backplane Backplane, config HubConfig) (*Hub, error) {
+	if config.Shards <= 0 {
+		config.Shards = runtime.NumCPU()
+	}
+	if config.InboxSize <= 0 {
+		config.InboxSize = defaultShardInboxSize
+	}
+	if config.SendQueueSize <= 0 {
+		config.SendQueueSize = defaultSendQueueSize
+	}
+	if config.Policies == nil {
+		config.Policies = DefaultSlowConsumerPolicies
+	}
+
+	h := &Hub{
+		overflow:      config.Overflow,
+		sendQueueSize: config.SendQueueSize,
+		policies:      config.Policies,
+		delivered:     make(chan deliveryReceipt, deliveryReceiptBufferSize),
+		handlers:      make(map[string]CommandHandler),
+		backplane:     backplane,
+		logger:        logger.New(),
+		done:          make(chan struct{}),
+	}
+
+	for i := 0; i < config.Shards; i++ {
+		h.shards = append(h.shards, newShard(h, config.InboxSize))
+	}
+
+	if err := backplane.Subscribe(h.receive, h.nodeDropped, h.eventsLost); err != nil {
+		return nil, fmt.Errorf("failed to subscribe to backplane: %w", err)
+	}
+
+	return h, nil
 }
 
 func (h *Hub) Run() {
-	for {
+	var wg sync.WaitGroup
+	for _, s := range h.shards {
+		wg.Add(1)
+		go func(s *shard) {
+			defer wg.Done()
+			s.run()
+		}(s)
+	}
+	wg.Wait()
+}
+
+func (h *Hub) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.done)
+	})
+}
+
+// Stats reports the hub's counters along with the clients whose send queues
+// are the most backed up.
+func (h *Hub) Stats() HubStats {
+	stats := HubStats{
+		Published: h.published.Load(),
+		Dropped:   h.dropped.Load(),
+	}
+
+	var clients []ClientStats
+	for _, s := range h.shards {
+		clients = append(clients, s.clientStats()...)
+	}
+
+	stats.Clients = len(clients)
+	for _, client := range clients {
+		stats.Queued += client.Queued
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Queued != clients[j].Queued {
+			return clients[i].Queued > clients[j].Queued
+		}
+		return clients[i].HighWater > clients[j].HighWater
+	})
+	if len(clients) > slowestClientsInStats {
+		clients = clients[:slowestClientsInStats]
+	}
+	stats.SlowestClients = clients
+
+	return stats
+}
+
+func (h *Hub) shardFor(userID uint) *shard {
+	return h.shards[userID%uint(len(h.shards))]
+}
+
+// receive hands an event coming from the backplane to the shards of its
+// recipients. It never blocks unless the overflow policy says so. The event
+// belongs to the hub by now, so the shards can keep its recipients.
+func (h *Hub) receive(event *Event) {
+	if event.Message == nil {
+		return
+	}
+	event.Message.Ephemeral = event.Ephemeral
+	event.Message.CoalesceKey = event.CoalesceKey
+
+	if observer, ok := h.observers.Load(event.Message.Type); ok {
+		observer.(func(message *Message))(event.Message)
+	}
+
+	if event.UserIDs == nil {
+		for _, s := range h.shards {
+			h.enqueue(s, shardOp{kind: opDeliver, message: event.Message})
+		}
+		return
+	}
+
+	if len(h.shards) == 1 {
+		h.enqueue(h.shards[0], shardOp{kind: opDeliver, userIDs: event.UserIDs, seqs: event.Seqs, message: event.Message})
+		return
+	}
+
+	byShard := make(map[*shard][]uint)
+	for _, userID := range event.UserIDs {
+		s := h.shardFor(userID)
+		byShard[s] = append(byShard[s], userID)
+	}
+
+	for s, userIDs := range byShard {
+		h.enqueue(s, shardOp{kind: opDeliver, userIDs: userIDs, seqs: event.Seqs, message: event.Message})
+	}
+}
+
+func (h *Hub) enqueue(s *shard, op shardOp) {
+	h.published.Add(1)
+
+	if h.overflow == OverflowBlock {
 		select {
-		case client := <-h.register:
-			h.mu.Lock()
-			if h.clients[client.userID] == nil {
-				h.clients[client.userID] = make(map[*Client]bool)
-			}
-			h.clients[client.userID][client] = true
-			h.mu.Unlock()
-
-		case client := <-h.unregister:
-			h.mu.Lock()
-			if clients, ok := h.clients[client.userID]; ok {
-				if _, exists := clients[client]; exists {
-					delete(clients, client)
-					close(client.send)
-					if len(clients) == 0 {
-						delete(h.clients, client.userID)
-					}
-				}
-			}
-			h.mu.Unlock()
-
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			var clientsToRemove []*Client
-			if message.RecipientID > 0 {
-				if clients, ok := h.clients[message.RecipientID]; ok {
-					for client := range clients {
-						select {
-						case client.send <- message:
-						default:
-							clientsToRemove = append(clientsToRemove, client)
-						}
-					}
-				}
-			} else {
-				for _, clients := range h.clients {
-					for client := range clients {
-						select {
-						case client.send <- message:
-						default:
-							clientsToRemove = append(clientsToRemove, client)
-						}
-					}
-				}
-			}
-			h.mu.RUnlock()
-
-			if len(clientsToRemove) > 0 {
-				h.mu.Lock()
-				for _, client := range clientsToRemove {
-					if clients, ok := h.clients[client.userID]; ok {
-						if _, exists := clients[client]; exists {
-							delete(clients, client)
-							close(client.send)
-							if len(clients) == 0 {
-								delete(h.clients, client.userID)
-							}
-						}
-					}
-				}
-				h.mu.Unlock()
-			}
+		case s.inbox <- op:
+		case <-h.done:
+		}
+		return
+	}
+
+	select {
+	case s.inbox <- op:
+	default:
+		h.dropped.Add(1)
+		hubOverflowDrops.Add(1)
+		if op.kind == opDeliver && !op.message.Ephemeral {
+			s.markOverflow(op.userIDs, op.seqs)
 		}
 	}
 }
 
+// Observe registers a function that is called with every event of the given
+// type, on every node, whether or not it has clients to deliver it to. It runs
+// on the backplane's delivery path and must return quickly.
+func (h *Hub) Observe(eventType string, observer func(message *Message)) {
+	h.observers.Store(eventType, observer)
+}
+
+// CheckUser registers the check that runs when a client's token expires. A
+// client whose user fails it is closed with CloseAuthenticationFailed rather
+// than CloseTokenExpired, so it does not try to refresh. It must be called
+// before clients connect.
+func (h *Hub) CheckUser(check UserCheck) {
+	h.checkUser = check
+}
+
+// expiryClose returns the close code and reason for a client whose token
+// expired.
+func (h *Hub) expiryClose(userID uint) (int, string) {
+	if h.checkUser != nil {
+		if err := h.checkUser(userID); err != nil {
+			return CloseAuthenticationFailed, err.Error()
+		}
+	}
+	return CloseTokenExpired, "token expired"
+}
+
+// HandleCommand registers the handler for client commands of the given type.
+// Handlers must be registered before clients connect.
+func (h *Hub) HandleCommand(commandType string, handler CommandHandler) {
+	h.handlers[commandType] = handler
+}
+
+func (h *Hub) dispatch(client *Client, command *Command) {
+	if command.Type == CommandDelivered {
+		var data ChatCommandData
+		if err := command.DecodeData(&data); err == nil && data.ChatID != 0 && data.MessageID != 0 {
+			h.reportDelivered(client.userID, data.ChatID, data.MessageID)
+		}
+		return
+	}
+
+	if handler, ok := h.handlers[command.Type]; ok {
+		handler(client, command)
+	}
+}
+
+// OnDelivered registers the handler that is called once a chat message has been
+// written to one of the recipient's connections or acknowledged by the client.
+// It must be called once, before clients connect.
+func (h *Hub) OnDelivered(handler DeliveryHandler) {
+	h.onDelivered = handler
+	go h.runDeliveryReceipts()
+}
+
+func (h *Hub) runDeliveryReceipts() {
+	for receipt := range h.delivered {
+		h.onDelivered(receipt.userID, receipt.chatID, receipt.messageID)
+	}
+}
+
+func (h *Hub) reportDelivered(userID uint, chatID uint, messageID uint) {
+	select {
+	case h.delivered <- deliveryReceipt{userID: userID, chatID: chatID, messageID: messageID}:
+	default:
+	}
+}
+
+func (m *Message) needsDeliveryReceipt(userID uint) bool {
+	return m != nil && m.Message != nil &&
+		(m.Type == "new_message" || m.Type == "thread_reply") &&
+		m.Message.AuthorID != userID
+}
+
+// OnPresence registers the handler that is called when a user's first
+// connection in the cluster registers or their last one goes away. It must be
+// called once, before clients connect.
+func (h *Hub) OnPresence(handler PresenceHandler) {
+	h.onPresence = handler
+}
+
+// notifyPresence reports the user's current state rather than the transition
+// that triggered it, so handlers running out of order still settle on the
+// right value.
+func (h *Hub) notifyPresence(userID uint) {
+	if h.onPresence == nil {
+		return
+	}
+
+	go func() {
+		h.onPresence(userID, h.IsOnline(userID))
+	}()
+}
+
+// nodeDropped is called by the backplane when the connections of a node that
+// stopped responding were discarded, possibly taking their users offline.
+func (h *Hub) nodeDropped(userIDs []uint) {
+	seen := make(map[uint]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if !seen[userID] {
+			seen[userID] = true
+			h.notifyPresence(userID)
+		}
+	}
+}
+
+// eventsLost is called by the backplane when it may have missed events for
+// this node. The resync goes through the inboxes regardless of the overflow
+// policy, so that it follows the events already queued.
+func (h *Hub) eventsLost() {
+	for _, s := range h.shards {
+		select {
+		case s.inbox <- shardOp{kind: opResync}:
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// IsOnline reports whether the user has a connection on any node.
+func (h *Hub) IsOnline(userID uint) bool {
+	return h.OnlineUsers([]uint{userID})[userID]
+}
+
+// OnlineUsers reports which of the users have a connection on any node. When
+// the backplane cannot be asked it falls back to this node's clients.
+func (h *Hub) OnlineUsers(userIDs []uint) map[uint]bool {
+	online, err := h.backplane.Online(userIDs)
+	if err == nil {
+		return online
+	}
+
+	h.logger.Error(fmt.Sprintf("Failed to read presence: %v", err))
+	online = make(map[uint]bool, len(userIDs))
+	for _, userID := range userIDs {
+		online[userID] = h.shardFor(userID).isOnline(userID)
+	}
+	return online
+}
+
 func (h *Hub) BroadcastToUser(userID uint, message *Message) {
-	message.RecipientID = userID
-	h.broadcast <- message
+	h.BroadcastToUsers([]uint{userID}, message)
+}
+
+func (h *Hub) BroadcastToUsers(userIDs []uint, message *Message) {
+	if len(userIDs) == 0 {
+		return
+	}
+	h.publish(&Event{UserIDs: userIDs, Message: message, Ephemeral: message.Ephemeral, CoalesceKey: message.CoalesceKey})
+}
+
+func (h *Hub) SendToClient(client *Client, message *Message) {
+	h.enqueue(h.shardFor(client.userID), shardOp{kind: opSendToClient, client: client, message: message})
+}
+
+// CloseClient closes the client's connection with the given close code once
+// the frames already queued for it are handled.
+func (h *Hub) CloseClient(client *Client, code int, reason string) {
+	h.enqueue(h.shardFor(client.userID), shardOp{kind: opClose, client: client, code: code, reason: reason})
 }
 
 func (h *Hub) BroadcastToAll(message *Message) {
-	message.RecipientID = 0
-	h.broadcast <- message
+	h.publish(&Event{Message: message, Ephemeral: message.Ephemeral, CoalesceKey: message.CoalesceKey})
+}
+
+func (h *Hub) publish(event *Event) {
+	if err := h.backplane.Publish(event); err != nil {
+		h.logger.Error(fmt.Sprintf("Failed to publish %s event: %v", event.Message.Type, err))
+	}
 }
 
+// Register adds the client to its shard. The current sequence number of the
+// user's stream is looked up first, so the shard can tell whether a resuming
+// client is up to date without waiting on the backplane. Every registered
+// client must be unregistered once its connection ends.
 func (h *Hub) Register(client *Client) {
-	h.register <- client
+	seq, err := h.backplane.Seq(client.userID)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("Failed to read sequence number of user %d: %v", client.userID, err))
+	}
+	client.seq = seq
+
+	s := h.shardFor(client.userID)
+	select {
+	case s.control <- shardOp{kind: opRegister, client: client}:
+	case <-h.done:
+		return
+	}
+
+	connections, err := h.backplane.Connect(client.userID)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("Failed to record connection of user %d: %v", client.userID, err))
+	}
+	if err != nil || connections == 1 {
+		h.notifyPresence(client.userID)
+	}
+}
+
+func (h *Hub) Unregister(client *Client) {
+	s := h.shardFor(client.userID)
+	select {
+	case s.control <- shardOp{kind: opUnregister, client: client}:
+	case <-h.done:
+		return
+	}
+
+	connections, err := h.backplane.Disconnect(client.userID)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("Failed to record disconnect of user %d: %v", client.userID, err))
+	}
+	if err != nil || connections == 0 {
+		h.notifyPresence(client.userID)
+	}
 }
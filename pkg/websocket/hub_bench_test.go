@@ -0,0 +1,151 @@
+package websocket
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	benchClients  = 10000
+	benchChatSize = 50
+)
+
+// benchHub counts the frames its simulated clients receive. wait sets want and
+// the drain goroutine that reaches it signals reached, so waiting costs
+// nothing while frames are being delivered.
+type benchHub struct {
+	hub       *Hub
+	delivered atomic.Uint64
+	want      atomic.Uint64
+	reached   chan struct{}
+}
+
+// newBenchHub starts a hub with benchClients simulated clients, one per user.
+// Clients have no connection; a goroutine drains each send queue instead of a
+// WritePump.
+func newBenchHub(b *testing.B, shards int) *benchHub {
+	b.Helper()
+
+	backplane := NewMemoryBackplane()
+	hub, err := NewHub(backplane, HubConfig{Shards: shards, Overflow: OverflowBlock})
+	if err != nil {
+		b.Fatal(err)
+	}
+	go hub.Run()
+	b.Cleanup(func() {
+		hub.Stop()
+		backplane.Close()
+	})
+
+	bh := &benchHub{hub: hub, reached: make(chan struct{}, 1)}
+	clients := make([]*Client, 0, benchClients)
+	for userID := uint(1); userID <= benchClients; userID++ {
+		client := NewClient(hub, nil, userID, 0, time.Time{})
+		go func() {
+			for range client.send.ready {
+				frames, closed, _, _ := client.send.drain()
+				if closed {
+					return
+				}
+				bh.count(len(frames))
+			}
+		}()
+		hub.Register(client)
+		clients = append(clients, client)
+	}
+	b.Cleanup(func() {
+		for _, client := range clients {
+			hub.Unregister(client)
+		}
+	})
+
+	bh.wait(b, benchClients)
+	bh.delivered.Store(0)
+
+	return bh
+}
+
+func (bh *benchHub) count(frames int) {
+	delivered := bh.delivered.Add(uint64(frames))
+	if want := bh.want.Load(); want != 0 && delivered >= want && bh.want.CompareAndSwap(want, 0) {
+		bh.reached <- struct{}{}
+	}
+}
+
+// wait blocks until the simulated clients have received want frames.
+func (bh *benchHub) wait(b *testing.B, want uint64) {
+	b.Helper()
+
+	bh.want.Store(want)
+	if bh.delivered.Load() >= want && bh.want.CompareAndSwap(want, 0) {
+		return
+	}
+
+	select {
+	case <-bh.reached:
+	case <-time.After(30 * time.Second):
+		b.Fatalf("delivered %d of %d frames", bh.delivered.Load(), want)
+	}
+}
+
+// benchShardCounts runs bench against a single shard and against one shard per
+// CPU. bench returns the number of frames it expects clients to receive.
+func benchShardCounts(b *testing.B, bench func(b *testing.B, bh *benchHub) uint64) {
+	shardCounts := []int{1}
+	if runtime.NumCPU() > 1 {
+		shardCounts = append(shardCounts, runtime.NumCPU())
+	}
+
+	for _, shards := range shardCounts {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			bh := newBenchHub(b, shards)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			bh.wait(b, bench(b, bh))
+
+			b.StopTimer()
+			b.ReportMetric(float64(bh.delivered.Load())/b.Elapsed().Seconds(), "frames/s")
+		})
+	}
+}
+
+func BenchmarkHubBroadcastToUser(b *testing.B) {
+	benchShardCounts(b, func(b *testing.B, bh *benchHub) uint64 {
+		for i := 0; i < b.N; i++ {
+			userID := uint(i%benchClients) + 1
+			bh.hub.BroadcastToUser(userID, &Message{Type: "new_message"})
+		}
+		return uint64(b.N)
+	})
+}
+
+func BenchmarkHubChatFanout(b *testing.B) {
+	benchShardCounts(b, func(b *testing.B, bh *benchHub) uint64 {
+		members := make([]uint, benchChatSize)
+		for i := 0; i < b.N; i++ {
+			first := uint(i*benchChatSize) % benchClients
+			for j := range members {
+				members[j] = (first+uint(j))%benchClients + 1
+			}
+			bh.hub.BroadcastToUsers(members, &Message{Type: "new_message"})
+		}
+		return uint64(b.N) * benchChatSize
+	})
+}
+
+func BenchmarkHubBroadcastParallel(b *testing.B) {
+	benchShardCounts(b, func(b *testing.B, bh *benchHub) uint64 {
+		var next atomic.Uint64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				userID := uint(next.Add(1)%benchClients) + 1
+				bh.hub.BroadcastToUser(userID, &Message{Type: "typing", Ephemeral: true})
+			}
+		})
+		return uint64(b.N)
+	})
+}
@@ -0,0 +1,176 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const testTimeout = 5 * time.Second
+
+// nextFrames waits for the client's queue to be signalled and drains it.
+func nextFrames(t *testing.T, client *Client) ([]frame, bool, int) {
+	t.Helper()
+
+	select {
+	case <-client.send.ready:
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for frames")
+	}
+
+	frames, closed, code, _ := client.send.drain()
+	return frames, closed, code
+}
+
+func newTestHub(t *testing.T, config HubConfig) *Hub {
+	t.Helper()
+
+	backplane := NewMemoryBackplane()
+	hub, err := NewHub(backplane, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		hub.Stop()
+		backplane.Close()
+	})
+	return hub
+}
+
+func TestHubOverflowBlockWaitsForRoom(t *testing.T) {
+	hub := newTestHub(t, HubConfig{Shards: 1, InboxSize: 1, Overflow: OverflowBlock})
+
+	hub.receive(&Event{UserIDs: []uint{1}, Seqs: map[uint]uint64{1: 1}, Message: &Message{Type: "new_message"}})
+
+	published := make(chan struct{})
+	go func() {
+		hub.receive(&Event{UserIDs: []uint{1}, Seqs: map[uint]uint64{1: 2}, Message: &Message{Type: "new_message"}})
+		close(published)
+	}()
+
+	select {
+	case <-published:
+		t.Fatal("publish did not wait for room in the full inbox")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	go hub.Run()
+
+	select {
+	case <-published:
+	case <-time.After(testTimeout):
+		t.Fatal("publish still blocked after the shard started")
+	}
+	if dropped := hub.Stats().Dropped; dropped != 0 {
+		t.Errorf("dropped = %d, want 0", dropped)
+	}
+}
+
+func TestHubOverflowResyncDropsAndResyncsRecipients(t *testing.T) {
+	hub := newTestHub(t, HubConfig{Shards: 1, InboxSize: 1, Overflow: OverflowResync})
+	client := NewClient(hub, nil, 1, 0, time.Time{})
+	hub.Register(client)
+
+	hub.receive(&Event{UserIDs: []uint{1}, Seqs: map[uint]uint64{1: 1}, Message: &Message{Type: "new_message"}})
+	hub.receive(&Event{UserIDs: []uint{1}, Seqs: map[uint]uint64{1: 2}, Message: &Message{Type: "new_message"}})
+
+	if dropped := hub.Stats().Dropped; dropped != 1 {
+		t.Fatalf("dropped = %d, want 1", dropped)
+	}
+
+	go hub.Run()
+
+	var types []string
+	for len(types) < 2 {
+		frames, _, _ := nextFrames(t, client)
+		for _, f := range frames {
+			types = append(types, f.message.Type)
+		}
+	}
+	if types[0] != "ready" || types[1] != "resync_required" {
+		t.Errorf("received %v, want ready then resync_required", types)
+	}
+}
+
+func TestHubDisconnectsSlowConsumer(t *testing.T) {
+	hub := newTestHub(t, HubConfig{Shards: 1, SendQueueSize: 2})
+	go hub.Run()
+
+	client := NewClient(hub, nil, 1, 0, time.Time{})
+	hub.Register(client)
+
+	// The queue is never drained, so the ready frame and the first event fill
+	// it and the second one does not fit.
+	for i := 0; i < 2; i++ {
+		hub.BroadcastToUser(1, &Message{Type: "new_message"})
+	}
+
+	deadline := time.After(testTimeout)
+	for {
+		select {
+		case <-client.send.ready:
+		case <-deadline:
+			t.Fatal("slow consumer was not disconnected")
+		}
+
+		client.send.mu.Lock()
+		closed, code := client.send.closed, client.send.closeCode
+		client.send.mu.Unlock()
+		if !closed {
+			continue
+		}
+		if code != websocket.CloseTryAgainLater {
+			t.Errorf("close code = %d, want %d", code, websocket.CloseTryAgainLater)
+		}
+		return
+	}
+}
+
+func TestHubKeepsSlowConsumerForExpendableEvents(t *testing.T) {
+	hub := newTestHub(t, HubConfig{Shards: 1, SendQueueSize: 2})
+	go hub.Run()
+
+	client := NewClient(hub, nil, 1, 0, time.Time{})
+	hub.Register(client)
+
+	for i := 0; i < 4; i++ {
+		hub.BroadcastToUser(1, &Message{Type: "typing", Ephemeral: true})
+	}
+	hub.BroadcastToUser(1, &Message{Type: "presence", CoalesceKey: "presence:2", Ephemeral: true})
+
+	for {
+		frames, closed, _ := nextFrames(t, client)
+		if closed {
+			t.Fatal("client was disconnected for expendable events")
+		}
+		if len(frames) > 0 && frames[len(frames)-1].message.Type == "presence" {
+			return
+		}
+	}
+}
+
+func TestHubResyncsEveryClientWhenEventsAreLost(t *testing.T) {
+	hub := newTestHub(t, HubConfig{Shards: 2})
+	go hub.Run()
+
+	clients := []*Client{NewClient(hub, nil, 1, 0, time.Time{}), NewClient(hub, nil, 2, 0, time.Time{})}
+	for _, client := range clients {
+		hub.Register(client)
+	}
+
+	hub.eventsLost()
+
+	for _, client := range clients {
+		var types []string
+		for len(types) < 2 {
+			frames, _, _ := nextFrames(t, client)
+			for _, f := range frames {
+				types = append(types, f.message.Type)
+			}
+		}
+		if types[0] != "ready" || types[1] != "resync_required" {
+			t.Errorf("user %d received %v, want ready then resync_required", client.userID, types)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"gin-real-time-talk/pkg/logger"
+)
+
+var (
+	ErrTooManyUserConnections = errors.New("too many connections for this user")
+	ErrTooManyIPConnections   = errors.New("too many connections from this address")
+)
+
+// CloseTooManyConnections closes a connection that exceeded a limit only known
+// after the upgrade, such as the per-user one for clients that authenticate
+// with an auth frame.
+const CloseTooManyConnections = 4029
+
+// ConnectionLimiter caps the concurrent connections per user and per remote
+// address across the cluster, counting them through the hub's backplane. A
+// limit of 0 disables it. When the backplane cannot be asked, the connection
+// is let through rather than refusing everyone while it is down.
+type ConnectionLimiter struct {
+	backplane  Backplane
+	maxPerUser int
+	maxPerIP   int
+	logger     *logger.Logger
+}
+
+func NewConnectionLimiter(hub *Hub, maxPerUser int, maxPerIP int) *ConnectionLimiter {
+	return &ConnectionLimiter{
+		backplane:  hub.backplane,
+		maxPerUser: maxPerUser,
+		maxPerIP:   maxPerIP,
+		logger:     logger.New(),
+	}
+}
+
+func (l *ConnectionLimiter) AcquireIP(ip string) error {
+	return l.acquire("ip:"+ip, l.maxPerIP, ErrTooManyIPConnections)
+}
+
+func (l *ConnectionLimiter) ReleaseIP(ip string) {
+	l.release("ip:" + ip)
+}
+
+func (l *ConnectionLimiter) AcquireUser(userID uint) error {
+	return l.acquire(userKey(userID), l.maxPerUser, ErrTooManyUserConnections)
+}
+
+func (l *ConnectionLimiter) ReleaseUser(userID uint) {
+	l.release(userKey(userID))
+}
+
+func (l *ConnectionLimiter) acquire(key string, limit int, limitErr error) error {
+	acquired, err := l.backplane.Acquire(key, limit)
+	if err != nil {
+		l.logger.Error(fmt.Sprintf("Failed to check connection limit for %s: %v", key, err))
+		return nil
+	}
+	if !acquired {
+		return limitErr
+	}
+	return nil
+}
+
+func (l *ConnectionLimiter) release(key string) {
+	if err := l.backplane.Release(key); err != nil {
+		l.logger.Error(fmt.Sprintf("Failed to release connection for %s: %v", key, err))
+	}
+}
+
+func userKey(userID uint) string {
+	return "user:" + strconv.FormatUint(uint64(userID), 10)
+}
@@ -0,0 +1,173 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+)
+
+const memoryBackplaneQueueSize = 1024
+
+// MemoryBackplane connects the hub of a single node to itself. Sequence
+// numbers are assigned under the lock, and events are queued in that order
+// for a goroutine that delivers them, so a hub that is slow to take an event
+// never holds up other publishers until the queue fills.
+type MemoryBackplane struct {
+	deliver     func(event *Event)
+	events      chan *Event
+	seqs        map[uint]uint64
+	baseSeq     uint64
+	connections map[uint]int
+	limits      map[string]int
+	done        chan struct{}
+	closeOnce   sync.Once
+	mu          sync.Mutex
+	connMu      sync.Mutex
+}
+
+func NewMemoryBackplane() *MemoryBackplane {
+	// Every stream starts from the current time so that sequence numbers keep
+	// growing across restarts and a client resuming from a previous run is
+	// detected.
+	return &MemoryBackplane{
+		events:      make(chan *Event, memoryBackplaneQueueSize),
+		seqs:        make(map[uint]uint64),
+		baseSeq:     uint64(time.Now().UnixMicro()),
+		connections: make(map[uint]int),
+		limits:      make(map[string]int),
+		done:        make(chan struct{}),
+	}
+}
+
+func (b *MemoryBackplane) Subscribe(deliver func(event *Event), dropped func(userIDs []uint), lost func()) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.deliver == nil {
+		go b.run()
+	}
+	b.deliver = deliver
+	return nil
+}
+
+// Publish queues a copy of the event, so the hub never shares the caller's
+// event, recipients or message.
+func (b *MemoryBackplane) Publish(event *Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.deliver == nil {
+		return nil
+	}
+
+	queued := *event
+	queued.Message = event.Message.clone()
+	if event.UserIDs != nil {
+		queued.UserIDs = append([]uint(nil), event.UserIDs...)
+	}
+	if !event.Ephemeral && event.UserIDs != nil {
+		queued.Seqs = make(map[uint]uint64, len(event.UserIDs))
+		for _, userID := range event.UserIDs {
+			if _, ok := queued.Seqs[userID]; !ok {
+				queued.Seqs[userID] = b.nextSeq(userID)
+			}
+		}
+	}
+
+	select {
+	case b.events <- &queued:
+	case <-b.done:
+	}
+	return nil
+}
+
+func (b *MemoryBackplane) run() {
+	for {
+		select {
+		case event := <-b.events:
+			b.deliver(event)
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *MemoryBackplane) Seq(userID uint) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if seq, ok := b.seqs[userID]; ok {
+		return seq, nil
+	}
+	return b.baseSeq, nil
+}
+
+func (b *MemoryBackplane) nextSeq(userID uint) uint64 {
+	seq, ok := b.seqs[userID]
+	if !ok {
+		seq = b.baseSeq
+	}
+	seq++
+	b.seqs[userID] = seq
+	return seq
+}
+
+func (b *MemoryBackplane) Connect(userID uint) (int, error) {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+
+	b.connections[userID]++
+	return b.connections[userID], nil
+}
+
+func (b *MemoryBackplane) Disconnect(userID uint) (int, error) {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+
+	if b.connections[userID] <= 1 {
+		delete(b.connections, userID)
+		return 0, nil
+	}
+	b.connections[userID]--
+	return b.connections[userID], nil
+}
+
+func (b *MemoryBackplane) Online(userIDs []uint) (map[uint]bool, error) {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+
+	online := make(map[uint]bool, len(userIDs))
+	for _, userID := range userIDs {
+		online[userID] = b.connections[userID] > 0
+	}
+	return online, nil
+}
+
+func (b *MemoryBackplane) Acquire(key string, limit int) (bool, error) {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+
+	if limit > 0 && b.limits[key] >= limit {
+		return false, nil
+	}
+	b.limits[key]++
+	return true, nil
+}
+
+func (b *MemoryBackplane) Release(key string) error {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+
+	if b.limits[key] <= 1 {
+		delete(b.limits, key)
+		return nil
+	}
+	b.limits[key]--
+	return nil
+}
+
+func (b *MemoryBackplane) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+	return nil
+}
@@ -0,0 +1,861 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gin-real-time-talk/pkg/logger"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+const (
+	postgresChannel          = "ws_events"
+	postgresSeqLock          = 7_231_001
+	postgresLimitLock        = 7_231_002
+	postgresMaxNotifyLength  = 7900
+	postgresPublishQueueSize = 1024
+	postgresBatchEvents      = 100
+	postgresBatchUsers       = 1000
+	postgresWriteAttempts    = 3
+	postgresCatchUpLimit     = 1000
+	postgresPendingPoll      = 100 * time.Millisecond
+	postgresEventRetention   = 10 * time.Minute
+	postgresPruneInterval    = time.Minute
+	postgresReconnectDelay   = 2 * time.Second
+	postgresHeartbeat        = 10 * time.Second
+	postgresNodeTimeout      = 30 * time.Second
+	ephemeralPayloadPrefix   = "e"
+)
+
+// PostgresBackplane shares events between nodes through Postgres. Published
+// events are queued for a writer goroutine, which stores them in ws_events in
+// batches and announces them with NOTIFY; every node LISTENs and reads new
+// rows in the order of the transactions that wrote them, which also lets a
+// reconnecting listener catch up on what it missed. The sequence number of
+// each user's stream is kept in ws_user_seqs and bumped in the same
+// transaction that stores the event, under a lock on that user only.
+// Ephemeral events are sent inline in the notification and are not stored.
+// Stored events are pruned after postgresEventRetention; a listener that fell
+// further behind skips what it missed and reports it as lost.
+//
+// Each node counts its connections per user in ws_connections, and those
+// under a connection limit in ws_limits, and keeps its ws_nodes row fresh with
+// a heartbeat. Only nodes with a fresh heartbeat are counted; the connections
+// of a node that stopped responding are deleted by whichever node notices
+// first.
+type PostgresBackplane struct {
+	db          *gorm.DB
+	dsn         string
+	nodeID      string
+	deliver     func(event *Event)
+	dropped     func(userIDs []uint)
+	lost        func()
+	events      chan *Event
+	lastTxID    uint64
+	lastID      uint64
+	connections map[uint]int
+	limits      map[string]int
+	connMu      sync.Mutex
+	logger      *logger.Logger
+	cancel      context.CancelFunc
+	done        chan struct{}
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+}
+
+func NewPostgresBackplane(db *gorm.DB, dsn string) *PostgresBackplane {
+	return &PostgresBackplane{
+		db:          db,
+		dsn:         dsn,
+		nodeID:      newNodeID(),
+		events:      make(chan *Event, postgresPublishQueueSize),
+		connections: make(map[uint]int),
+		limits:      make(map[string]int),
+		logger:      logger.New(),
+		done:        make(chan struct{}),
+	}
+}
+
+func newNodeID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "node"
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+func (b *PostgresBackplane) Subscribe(deliver func(event *Event), dropped func(userIDs []uint), lost func()) error {
+	if err := b.db.Exec(`
+		CREATE TABLE IF NOT EXISTS ws_events (
+			id BIGSERIAL PRIMARY KEY,
+			payload JSONB NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`).Error; err != nil {
+		return fmt.Errorf("failed to create ws_events table: %w", err)
+	}
+
+	if err := b.db.Exec(
+		"ALTER TABLE ws_events ADD COLUMN IF NOT EXISTS txid xid8 NOT NULL DEFAULT pg_current_xact_id()",
+	).Error; err != nil {
+		return fmt.Errorf("failed to add ws_events txid column: %w", err)
+	}
+
+	if err := b.db.Exec("CREATE INDEX IF NOT EXISTS idx_ws_events_txid_id ON ws_events (txid, id)").Error; err != nil {
+		return fmt.Errorf("failed to create ws_events index: %w", err)
+	}
+
+	if err := b.db.Exec(`
+		CREATE TABLE IF NOT EXISTS ws_pruned_events (
+			singleton BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (singleton),
+			txid xid8 NOT NULL,
+			event_id BIGINT NOT NULL
+		)
+	`).Error; err != nil {
+		return fmt.Errorf("failed to create ws_pruned_events table: %w", err)
+	}
+
+	if err := b.db.Exec(`
+		CREATE TABLE IF NOT EXISTS ws_user_seqs (
+			user_id BIGINT PRIMARY KEY,
+			seq BIGINT NOT NULL
+		)
+	`).Error; err != nil {
+		return fmt.Errorf("failed to create ws_user_seqs table: %w", err)
+	}
+
+	if err := b.db.Exec(`
+		CREATE TABLE IF NOT EXISTS ws_nodes (
+			node_id TEXT PRIMARY KEY,
+			heartbeat_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`).Error; err != nil {
+		return fmt.Errorf("failed to create ws_nodes table: %w", err)
+	}
+
+	if err := b.db.Exec(`
+		CREATE TABLE IF NOT EXISTS ws_connections (
+			node_id TEXT NOT NULL,
+			user_id BIGINT NOT NULL,
+			connections INT NOT NULL,
+			PRIMARY KEY (node_id, user_id)
+		)
+	`).Error; err != nil {
+		return fmt.Errorf("failed to create ws_connections table: %w", err)
+	}
+
+	if err := b.db.Exec("CREATE INDEX IF NOT EXISTS idx_ws_connections_user_id ON ws_connections (user_id)").Error; err != nil {
+		return fmt.Errorf("failed to create ws_connections index: %w", err)
+	}
+
+	if err := b.db.Exec(`
+		CREATE TABLE IF NOT EXISTS ws_limits (
+			node_id TEXT NOT NULL,
+			limit_key TEXT NOT NULL,
+			connections INT NOT NULL,
+			PRIMARY KEY (node_id, limit_key)
+		)
+	`).Error; err != nil {
+		return fmt.Errorf("failed to create ws_limits table: %w", err)
+	}
+
+	if err := b.db.Exec("CREATE INDEX IF NOT EXISTS idx_ws_limits_limit_key ON ws_limits (limit_key)").Error; err != nil {
+		return fmt.Errorf("failed to create ws_limits index: %w", err)
+	}
+
+	if _, err := b.heartbeat(); err != nil {
+		return fmt.Errorf("failed to register backplane node: %w", err)
+	}
+
+	// Events of transactions older than every running one have been written
+	// before this node started; anything from the rest is new.
+	var lastTxID uint64
+	if err := b.db.Raw("SELECT pg_snapshot_xmin(pg_current_snapshot())::text::bigint").Scan(&lastTxID).Error; err != nil {
+		return fmt.Errorf("failed to read the current transaction horizon: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.deliver = deliver
+	b.dropped = dropped
+	b.lost = lost
+	b.lastTxID = lastTxID
+	b.cancel = cancel
+
+	b.wg.Add(4)
+	go b.write()
+	go b.listen(ctx)
+	go b.prune(ctx)
+	go b.keepAlive(ctx)
+
+	return nil
+}
+
+// Publish queues a copy of the event for the writer, so the caller only waits
+// on the database when the queue is full.
+func (b *PostgresBackplane) Publish(event *Event) error {
+	queued := *event
+	queued.Message = event.Message.clone()
+	if event.UserIDs != nil {
+		queued.UserIDs = append([]uint(nil), event.UserIDs...)
+	}
+
+	select {
+	case b.events <- &queued:
+		return nil
+	case <-b.done:
+		return errors.New("backplane is closed")
+	}
+}
+
+// write stores queued events until the backplane is closed, and then the ones
+// still queued.
+func (b *PostgresBackplane) write() {
+	defer b.wg.Done()
+
+	for {
+		select {
+		case event := <-b.events:
+			b.writeBatch(b.nextBatch(event))
+		case <-b.done:
+			for len(b.events) > 0 {
+				b.writeBatch(b.nextBatch(<-b.events))
+			}
+			return
+		}
+	}
+}
+
+// nextBatch adds whatever else is already queued to event, up to a size that
+// keeps the number of locks a transaction takes bounded.
+func (b *PostgresBackplane) nextBatch(event *Event) []*Event {
+	batch := []*Event{event}
+	users := len(event.UserIDs)
+
+	for len(batch) < postgresBatchEvents && users < postgresBatchUsers {
+		select {
+		case event := <-b.events:
+			batch = append(batch, event)
+			users += len(event.UserIDs)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (b *PostgresBackplane) writeBatch(batch []*Event) {
+	for attempt := 1; ; attempt++ {
+		err := b.db.Transaction(func(tx *gorm.DB) error {
+			return b.store(tx, batch)
+		})
+		if err == nil {
+			return
+		}
+
+		if attempt == postgresWriteAttempts {
+			b.logger.Error(fmt.Sprintf("Dropping %d backplane events: %v", len(batch), err))
+			return
+		}
+		b.logger.Error(fmt.Sprintf("Failed to store backplane events, retrying: %v", err))
+		time.Sleep(postgresReconnectDelay)
+	}
+}
+
+// store writes the batch in one transaction. Every recipient is locked before
+// the first write, in id order: a transaction that shares a recipient with
+// another one then only gets its transaction id once the other has committed,
+// so listeners reading in transaction id order see every stream in sequence
+// order, while transactions for different users never wait on each other.
+func (b *PostgresBackplane) store(tx *gorm.DB, batch []*Event) error {
+	if userIDs := batchRecipients(batch); len(userIDs) > 0 {
+		if err := tx.Exec(
+			"SELECT pg_advisory_xact_lock(?, (user_id & 2147483647)::int) FROM unnest(?::bigint[]) AS user_id",
+			postgresSeqLock, bigintArray(userIDs),
+		).Error; err != nil {
+			return err
+		}
+	}
+
+	stored := false
+	for _, event := range batch {
+		if event.Ephemeral {
+			payload, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+
+			notification := ephemeralPayloadPrefix + string(payload)
+			if len(notification) > postgresMaxNotifyLength {
+				b.logger.Error(fmt.Sprintf("Dropping ephemeral %s event of %d bytes", event.Message.Type, len(notification)))
+				continue
+			}
+			if err := tx.Exec("SELECT pg_notify(?, ?)", postgresChannel, notification).Error; err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := b.assignSeqs(tx, event); err != nil {
+			return err
+		}
+
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Exec("INSERT INTO ws_events (payload) VALUES (?)", string(payload)).Error; err != nil {
+			return err
+		}
+		stored = true
+	}
+
+	if !stored {
+		return nil
+	}
+	return tx.Exec("SELECT pg_notify(?, '')", postgresChannel).Error
+}
+
+// batchRecipients returns the distinct recipients of the batch's sequenced
+// events in ascending order.
+func batchRecipients(batch []*Event) []uint {
+	seen := make(map[uint]bool)
+	var userIDs []uint
+	for _, event := range batch {
+		if event.Ephemeral {
+			continue
+		}
+		for _, userID := range event.UserIDs {
+			if !seen[userID] {
+				seen[userID] = true
+				userIDs = append(userIDs, userID)
+			}
+		}
+	}
+
+	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+	return userIDs
+}
+
+// assignSeqs bumps the stream of every recipient and records the new sequence
+// numbers on the event.
+func (b *PostgresBackplane) assignSeqs(tx *gorm.DB, event *Event) error {
+	if event.UserIDs == nil {
+		return nil
+	}
+
+	var seqs []struct {
+		UserID uint
+		Seq    uint64
+	}
+	if err := tx.Raw(`
+		INSERT INTO ws_user_seqs (user_id, seq)
+		SELECT DISTINCT unnest(?::bigint[]), 1
+		ON CONFLICT (user_id) DO UPDATE SET seq = ws_user_seqs.seq + 1
+		RETURNING user_id, seq
+	`, bigintArray(event.UserIDs)).Scan(&seqs).Error; err != nil {
+		return err
+	}
+
+	event.Seqs = make(map[uint]uint64, len(seqs))
+	for _, seq := range seqs {
+		event.Seqs[seq.UserID] = seq.Seq
+	}
+	return nil
+}
+
+func (b *PostgresBackplane) Seq(userID uint) (uint64, error) {
+	var seq uint64
+	err := b.db.Raw("SELECT COALESCE((SELECT seq FROM ws_user_seqs WHERE user_id = ?), 0)", userID).Scan(&seq).Error
+	return seq, err
+}
+
+func (b *PostgresBackplane) Connect(userID uint) (int, error) {
+	b.connMu.Lock()
+	b.connections[userID]++
+	b.connMu.Unlock()
+
+	if err := b.db.Exec(`
+		INSERT INTO ws_connections (node_id, user_id, connections) VALUES (?, ?, 1)
+		ON CONFLICT (node_id, user_id) DO UPDATE SET connections = ws_connections.connections + 1
+	`, b.nodeID, userID).Error; err != nil {
+		return 0, err
+	}
+	return b.clusterConnections(userID)
+}
+
+func (b *PostgresBackplane) Disconnect(userID uint) (int, error) {
+	b.connMu.Lock()
+	if b.connections[userID] <= 1 {
+		delete(b.connections, userID)
+	} else {
+		b.connections[userID]--
+	}
+	b.connMu.Unlock()
+
+	if err := b.db.Exec(
+		"UPDATE ws_connections SET connections = connections - 1 WHERE node_id = ? AND user_id = ?",
+		b.nodeID, userID,
+	).Error; err != nil {
+		return 0, err
+	}
+	return b.clusterConnections(userID)
+}
+
+func (b *PostgresBackplane) clusterConnections(userID uint) (int, error) {
+	var count int
+	err := b.db.Raw(`
+		SELECT COALESCE(SUM(c.connections), 0) FROM ws_connections c
+		JOIN ws_nodes n ON n.node_id = c.node_id
+		WHERE c.user_id = ? AND n.heartbeat_at > NOW() - make_interval(secs => ?)
+	`, userID, postgresNodeTimeout.Seconds()).Scan(&count).Error
+	return count, err
+}
+
+func (b *PostgresBackplane) Online(userIDs []uint) (map[uint]bool, error) {
+	online := make(map[uint]bool, len(userIDs))
+	if len(userIDs) == 0 {
+		return online, nil
+	}
+
+	var onlineIDs []uint
+	if err := b.db.Raw(`
+		SELECT DISTINCT c.user_id FROM ws_connections c
+		JOIN ws_nodes n ON n.node_id = c.node_id
+		WHERE c.user_id = ANY(?::bigint[]) AND c.connections > 0
+			AND n.heartbeat_at > NOW() - make_interval(secs => ?)
+	`, bigintArray(userIDs), postgresNodeTimeout.Seconds()).Scan(&onlineIDs).Error; err != nil {
+		return nil, err
+	}
+
+	for _, userID := range userIDs {
+		online[userID] = false
+	}
+	for _, userID := range onlineIDs {
+		online[userID] = true
+	}
+	return online, nil
+}
+
+// Acquire checks and counts the connection in one transaction, under a lock on
+// the key so that two nodes cannot both take the last slot.
+func (b *PostgresBackplane) Acquire(key string, limit int) (bool, error) {
+	acquired := false
+	err := b.db.Transaction(func(tx *gorm.DB) error {
+		if limit > 0 {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?, hashtext(?))", postgresLimitLock, key).Error; err != nil {
+				return err
+			}
+
+			var count int
+			if err := tx.Raw(`
+				SELECT COALESCE(SUM(l.connections), 0) FROM ws_limits l
+				JOIN ws_nodes n ON n.node_id = l.node_id
+				WHERE l.limit_key = ? AND n.heartbeat_at > NOW() - make_interval(secs => ?)
+			`, key, postgresNodeTimeout.Seconds()).Scan(&count).Error; err != nil {
+				return err
+			}
+			if count >= limit {
+				return nil
+			}
+		}
+
+		if err := tx.Exec(`
+			INSERT INTO ws_limits (node_id, limit_key, connections) VALUES (?, ?, 1)
+			ON CONFLICT (node_id, limit_key) DO UPDATE SET connections = ws_limits.connections + 1
+		`, b.nodeID, key).Error; err != nil {
+			return err
+		}
+
+		acquired = true
+		return nil
+	})
+	if err != nil || !acquired {
+		return false, err
+	}
+
+	b.connMu.Lock()
+	b.limits[key]++
+	b.connMu.Unlock()
+	return true, nil
+}
+
+func (b *PostgresBackplane) Release(key string) error {
+	b.connMu.Lock()
+	if b.limits[key] <= 1 {
+		delete(b.limits, key)
+	} else {
+		b.limits[key]--
+	}
+	b.connMu.Unlock()
+
+	return b.db.Exec(
+		"UPDATE ws_limits SET connections = connections - 1 WHERE node_id = ? AND limit_key = ?",
+		b.nodeID, key,
+	).Error
+}
+
+func (b *PostgresBackplane) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+	if b.cancel != nil {
+		b.cancel()
+	}
+	b.wg.Wait()
+
+	if err := b.db.Exec("DELETE FROM ws_connections WHERE node_id = ?", b.nodeID).Error; err != nil {
+		return err
+	}
+	if err := b.db.Exec("DELETE FROM ws_limits WHERE node_id = ?", b.nodeID).Error; err != nil {
+		return err
+	}
+	return b.db.Exec("DELETE FROM ws_nodes WHERE node_id = ?", b.nodeID).Error
+}
+
+// heartbeat refreshes this node's row and reports whether it had to be
+// created, which happens on start and after other nodes gave up on this one.
+func (b *PostgresBackplane) heartbeat() (bool, error) {
+	var created bool
+	err := b.db.Raw(`
+		INSERT INTO ws_nodes (node_id, heartbeat_at) VALUES (?, NOW())
+		ON CONFLICT (node_id) DO UPDATE SET heartbeat_at = NOW()
+		RETURNING xmax = 0
+	`, b.nodeID).Scan(&created).Error
+	return created, err
+}
+
+// restoreConnections writes this node's connection and limit counts back after
+// other nodes deleted them.
+func (b *PostgresBackplane) restoreConnections() error {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+
+	return b.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM ws_connections WHERE node_id = ?", b.nodeID).Error; err != nil {
+			return err
+		}
+		for userID, count := range b.connections {
+			if err := tx.Exec(
+				"INSERT INTO ws_connections (node_id, user_id, connections) VALUES (?, ?, ?)",
+				b.nodeID, userID, count,
+			).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Exec("DELETE FROM ws_limits WHERE node_id = ?", b.nodeID).Error; err != nil {
+			return err
+		}
+		for key, count := range b.limits {
+			if err := tx.Exec(
+				"INSERT INTO ws_limits (node_id, limit_key, connections) VALUES (?, ?, ?)",
+				b.nodeID, key, count,
+			).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// dropDeadNodes deletes nodes whose heartbeat is too old along with their
+// connections, and reports the users that had any.
+func (b *PostgresBackplane) dropDeadNodes() ([]uint, error) {
+	var userIDs []uint
+	err := b.db.Raw(`
+		WITH dead AS (
+			DELETE FROM ws_nodes WHERE heartbeat_at < NOW() - make_interval(secs => ?)
+			RETURNING node_id
+		), limits AS (
+			DELETE FROM ws_limits WHERE node_id IN (SELECT node_id FROM dead)
+		)
+		DELETE FROM ws_connections WHERE node_id IN (SELECT node_id FROM dead)
+		RETURNING user_id
+	`, postgresNodeTimeout.Seconds()).Scan(&userIDs).Error
+	return userIDs, err
+}
+
+func (b *PostgresBackplane) keepAlive(ctx context.Context) {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(postgresHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		created, err := b.heartbeat()
+		if err != nil {
+			b.logger.Error(fmt.Sprintf("Backplane heartbeat failed: %v", err))
+			continue
+		}
+		if created {
+			if err := b.restoreConnections(); err != nil {
+				b.logger.Error(fmt.Sprintf("Failed to restore backplane connections: %v", err))
+			}
+		}
+
+		if err := b.db.Exec("DELETE FROM ws_connections WHERE node_id = ? AND connections <= 0", b.nodeID).Error; err != nil {
+			b.logger.Error(fmt.Sprintf("Failed to prune backplane connections: %v", err))
+		}
+		if err := b.db.Exec("DELETE FROM ws_limits WHERE node_id = ? AND connections <= 0", b.nodeID).Error; err != nil {
+			b.logger.Error(fmt.Sprintf("Failed to prune backplane limits: %v", err))
+		}
+
+		userIDs, err := b.dropDeadNodes()
+		if err != nil {
+			b.logger.Error(fmt.Sprintf("Failed to drop dead backplane nodes: %v", err))
+			continue
+		}
+		if len(userIDs) > 0 && b.dropped != nil {
+			b.dropped(userIDs)
+		}
+	}
+}
+
+// bigintArray formats the IDs as a Postgres array literal, to be cast with
+// ?::bigint[].
+func bigintArray(ids []uint) string {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return "{" + strings.Join(values, ",") + "}"
+}
+
+func (b *PostgresBackplane) listen(ctx context.Context) {
+	defer b.wg.Done()
+
+	for ctx.Err() == nil {
+		if err := b.listenOnce(ctx); err != nil && ctx.Err() == nil {
+			b.logger.Error(fmt.Sprintf("Backplane listener failed: %v", err))
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(postgresReconnectDelay):
+			}
+		}
+	}
+}
+
+func (b *PostgresBackplane) listenOnce(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, b.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+postgresChannel); err != nil {
+		return err
+	}
+
+	pending, err := b.catchUp(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for {
+		notification, err := waitForNotification(ctx, conn, pending)
+		if err != nil {
+			return err
+		}
+
+		if notification != nil && strings.HasPrefix(notification.Payload, ephemeralPayloadPrefix) {
+			b.deliverEphemeral(notification.Payload[len(ephemeralPayloadPrefix):])
+			continue
+		}
+
+		if pending, err = b.catchUp(ctx, conn); err != nil {
+			return err
+		}
+	}
+}
+
+// waitForNotification waits for the next notification, but only briefly while
+// events are held back behind a running transaction, since nothing else may be
+// published to announce them; a nil notification means it is time to look
+// again.
+func waitForNotification(ctx context.Context, conn *pgx.Conn, pending bool) (*pgconn.Notification, error) {
+	if !pending {
+		return conn.WaitForNotification(ctx)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, postgresPendingPoll)
+	defer cancel()
+
+	notification, err := conn.WaitForNotification(waitCtx)
+	if err != nil && ctx.Err() == nil && pgconn.Timeout(err) {
+		return nil, nil
+	}
+	return notification, err
+}
+
+// catchUp delivers the stored events after the cursor in the order of the
+// transactions that wrote them. Only transactions older than every running one
+// are read, so that one committing later can never land behind the cursor;
+// catchUp reports whether newer events had to be left for later.
+func (b *PostgresBackplane) catchUp(ctx context.Context, conn *pgx.Conn) (bool, error) {
+	type storedEvent struct {
+		id      uint64
+		txID    uint64
+		payload []byte
+		settled bool
+	}
+
+	lost, err := b.skipPruned(ctx, conn)
+	if err != nil {
+		return false, err
+	}
+	if lost && b.lost != nil {
+		b.lost()
+	}
+
+	for {
+		rows, err := conn.Query(ctx, `
+			SELECT id, txid::text::bigint, payload, txid < pg_snapshot_xmin(pg_current_snapshot())
+			FROM ws_events WHERE (txid, id) > ($1::text::xid8, $2)
+			ORDER BY txid, id LIMIT $3
+		`, strconv.FormatUint(b.lastTxID, 10), b.lastID, postgresCatchUpLimit)
+		if err != nil {
+			return false, err
+		}
+
+		var events []storedEvent
+		for rows.Next() {
+			var event storedEvent
+			if err := rows.Scan(&event.id, &event.txID, &event.payload, &event.settled); err != nil {
+				rows.Close()
+				return false, err
+			}
+			events = append(events, event)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return false, err
+		}
+
+		for _, stored := range events {
+			if !stored.settled {
+				return true, nil
+			}
+			b.lastTxID, b.lastID = stored.txID, stored.id
+
+			var event Event
+			if err := json.Unmarshal(stored.payload, &event); err != nil || event.Message == nil {
+				b.logger.Error(fmt.Sprintf("Skipping malformed backplane event %d", stored.id))
+				continue
+			}
+
+			b.deliver(&event)
+		}
+
+		if len(events) < postgresCatchUpLimit {
+			return false, nil
+		}
+	}
+}
+
+// skipPruned moves the cursor past the events that were pruned before this
+// node read them, and reports whether there were any.
+func (b *PostgresBackplane) skipPruned(ctx context.Context, conn *pgx.Conn) (bool, error) {
+	var txID, id uint64
+	err := conn.QueryRow(ctx, "SELECT txid::text::bigint, event_id FROM ws_pruned_events").Scan(&txID, &id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if txID < b.lastTxID || (txID == b.lastTxID && id <= b.lastID) {
+		return false, nil
+	}
+	b.logger.Error(fmt.Sprintf("Backplane events up to %d were pruned before they were read", id))
+	b.lastTxID, b.lastID = txID, id
+	return true, nil
+}
+
+func (b *PostgresBackplane) deliverEphemeral(payload string) {
+	var event Event
+	if err := json.Unmarshal([]byte(payload), &event); err != nil || event.Message == nil {
+		return
+	}
+
+	event.Ephemeral = true
+	b.deliver(&event)
+}
+
+func (b *PostgresBackplane) prune(ctx context.Context) {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(postgresPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.pruneEvents(time.Now().Add(-postgresEventRetention)); err != nil {
+				b.logger.Error(fmt.Sprintf("Failed to prune backplane events: %v", err))
+			}
+		}
+	}
+}
+
+// pruneEvents deletes the events written before cutoff, up to the last one in
+// the order listeners read them, and records that one so that a listener
+// still behind it knows it missed events. Only transactions older than every
+// running one are considered, so nothing can commit behind the record.
+func (b *PostgresBackplane) pruneEvents(cutoff time.Time) error {
+	return b.db.Transaction(func(tx *gorm.DB) error {
+		var last []struct {
+			TxID uint64
+			ID   uint64
+		}
+		if err := tx.Raw(`
+			SELECT txid::text::bigint AS tx_id, id FROM ws_events
+			WHERE created_at < ? AND txid < pg_snapshot_xmin(pg_current_snapshot())
+			ORDER BY txid DESC, id DESC LIMIT 1
+		`, cutoff).Scan(&last).Error; err != nil {
+			return err
+		}
+		if len(last) == 0 {
+			return nil
+		}
+
+		txID := strconv.FormatUint(last[0].TxID, 10)
+		if err := tx.Exec(
+			"DELETE FROM ws_events WHERE (txid, id) <= (?::text::xid8, ?)", txID, last[0].ID,
+		).Error; err != nil {
+			return err
+		}
+
+		return tx.Exec(`
+			INSERT INTO ws_pruned_events (txid, event_id) VALUES (?::text::xid8, ?)
+			ON CONFLICT (singleton) DO UPDATE SET txid = EXCLUDED.txid, event_id = EXCLUDED.event_id
+			WHERE (ws_pruned_events.txid, ws_pruned_events.event_id) < (EXCLUDED.txid, EXCLUDED.event_id)
+		`, txID, last[0].ID).Error
+	})
+}
@@ -0,0 +1,193 @@
+package websocket
+
+import (
+	"expvar"
+	"sync"
+)
+
+const defaultSendQueueSize = 256
+
+// SlowConsumerPolicy decides what happens to an event of a given type when a
+// client's send queue is full.
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerDisconnect closes the connection with CloseTryAgainLater.
+	SlowConsumerDisconnect SlowConsumerPolicy = iota
+	// SlowConsumerDropOldest marks the event type as expendable: the oldest
+	// queued event of such a type makes room for newer ones, and when there is
+	// none the event itself is dropped.
+	SlowConsumerDropOldest
+	// SlowConsumerCoalesce replaces a queued event with the same CoalesceKey
+	// and otherwise behaves like SlowConsumerDropOldest.
+	SlowConsumerCoalesce
+)
+
+// DefaultSlowConsumerPolicies covers the event types that a client can miss
+// without going out of sync. Every other type disconnects a slow client.
+var DefaultSlowConsumerPolicies = map[string]SlowConsumerPolicy{
+	"typing":   SlowConsumerDropOldest,
+	"presence": SlowConsumerCoalesce,
+}
+
+var (
+	metrics                 = expvar.NewMap("websocket")
+	droppedEvents           = new(expvar.Map).Init()
+	coalescedEvents         = new(expvar.Map).Init()
+	slowConsumerDisconnects = new(expvar.Int)
+	hubOverflowDrops        = new(expvar.Int)
+)
+
+func init() {
+	metrics.Set("droppedEvents", droppedEvents)
+	metrics.Set("coalescedEvents", coalescedEvents)
+	metrics.Set("slowConsumerDisconnects", slowConsumerDisconnects)
+	metrics.Set("hubOverflowDrops", hubOverflowDrops)
+}
+
+// frame is a message as queued for one user. seq is its sequence number in the
+// user's stream, or 0 when the message is not sequenced.
+type frame struct {
+	message *Message
+	seq     uint64
+}
+
+// sendQueue buffers the frames waiting to be written to one connection. The
+// hub pushes, WritePump drains everything queued at once.
+type sendQueue struct {
+	frames      []frame
+	size        int
+	policies    map[string]SlowConsumerPolicy
+	ready       chan struct{}
+	closed      bool
+	closeCode   int
+	closeReason string
+	highWater   int
+	dropped     uint64
+	coalesced   uint64
+	mu          sync.Mutex
+}
+
+func newSendQueue(size int, policies map[string]SlowConsumerPolicy) *sendQueue {
+	return &sendQueue{
+		size:     size,
+		policies: policies,
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+// push queues the frame and reports false when it did not fit and the client
+// has to be disconnected.
+func (q *sendQueue) push(f frame) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return true
+	}
+
+	message := f.message
+	policy := q.policies[message.Type]
+	if policy == SlowConsumerCoalesce && message.CoalesceKey != "" {
+		if i := q.indexOf(func(m *Message) bool { return m.CoalesceKey == message.CoalesceKey }); i >= 0 {
+			q.remove(i)
+			q.coalesced++
+			coalescedEvents.Add(message.Type, 1)
+		}
+	}
+
+	if len(q.frames) == q.size {
+		i := q.indexOf(func(m *Message) bool { return q.policies[m.Type] != SlowConsumerDisconnect })
+		switch {
+		case i >= 0:
+			q.drop(q.frames[i].message)
+			q.remove(i)
+		case policy != SlowConsumerDisconnect:
+			q.drop(message)
+			return true
+		default:
+			return false
+		}
+	}
+
+	q.frames = append(q.frames, f)
+	q.highWater = max(q.highWater, len(q.frames))
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// pushAll queues the frames only if all of them fit without dropping any.
+func (q *sendQueue) pushAll(frames []frame) bool {
+	q.mu.Lock()
+	if q.closed || len(frames) > q.size-len(q.frames) {
+		q.mu.Unlock()
+		return false
+	}
+	q.mu.Unlock()
+
+	for _, f := range frames {
+		q.push(f)
+	}
+	return true
+}
+
+// drain takes every queued frame. Once the queue is closed it returns the close
+// code and reason to send instead.
+func (q *sendQueue) drain() ([]frame, bool, int, string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, true, q.closeCode, q.closeReason
+	}
+
+	frames := q.frames
+	q.frames = nil
+	return frames, false, 0, ""
+}
+
+func (q *sendQueue) close(code int, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.closeCode = code
+	q.closeReason = reason
+	q.frames = nil
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *sendQueue) stats() (int, int, uint64, uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.frames), q.highWater, q.dropped, q.coalesced
+}
+
+func (q *sendQueue) indexOf(match func(m *Message) bool) int {
+	for i, f := range q.frames {
+		if match(f.message) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *sendQueue) remove(i int) {
+	q.frames = append(q.frames[:i], q.frames[i+1:]...)
+}
+
+func (q *sendQueue) drop(message *Message) {
+	q.dropped++
+	droppedEvents.Add(message.Type, 1)
+}
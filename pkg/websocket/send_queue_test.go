@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"testing"
+)
+
+func queuedTypes(q *sendQueue) []string {
+	var types []string
+	for _, f := range q.frames {
+		types = append(types, f.message.Type)
+	}
+	return types
+}
+
+func TestSendQueueDropOldest(t *testing.T) {
+	q := newSendQueue(2, DefaultSlowConsumerPolicies)
+
+	first := &Message{Type: "typing"}
+	for _, message := range []*Message{first, {Type: "typing"}, {Type: "typing"}} {
+		if !q.push(frame{message: message}) {
+			t.Fatal("push of a drop_oldest event asked to disconnect")
+		}
+	}
+
+	if len(q.frames) != 2 {
+		t.Fatalf("queued %d frames, want 2", len(q.frames))
+	}
+	if q.frames[0].message == first {
+		t.Error("oldest typing event was not dropped")
+	}
+	if _, _, dropped, _ := q.stats(); dropped != 1 {
+		t.Errorf("dropped = %d, want 1", dropped)
+	}
+}
+
+func TestSendQueueDropOldestMakesRoomForOtherTypes(t *testing.T) {
+	q := newSendQueue(2, DefaultSlowConsumerPolicies)
+
+	q.push(frame{message: &Message{Type: "new_message"}})
+	q.push(frame{message: &Message{Type: "typing"}})
+	if !q.push(frame{message: &Message{Type: "new_message"}}) {
+		t.Fatal("queued typing event did not make room")
+	}
+
+	got := queuedTypes(q)
+	if len(got) != 2 || got[0] != "new_message" || got[1] != "new_message" {
+		t.Errorf("queued %v, want [new_message new_message]", got)
+	}
+}
+
+func TestSendQueueDropsExpendableEventWhenFull(t *testing.T) {
+	q := newSendQueue(1, DefaultSlowConsumerPolicies)
+
+	q.push(frame{message: &Message{Type: "new_message"}})
+	if !q.push(frame{message: &Message{Type: "typing"}}) {
+		t.Fatal("push of a drop_oldest event asked to disconnect")
+	}
+
+	if got := queuedTypes(q); len(got) != 1 || got[0] != "new_message" {
+		t.Errorf("queued %v, want [new_message]", got)
+	}
+}
+
+func TestSendQueueCoalesce(t *testing.T) {
+	q := newSendQueue(4, DefaultSlowConsumerPolicies)
+
+	stale := &Message{Type: "presence", CoalesceKey: "presence:1"}
+	other := &Message{Type: "presence", CoalesceKey: "presence:2"}
+	fresh := &Message{Type: "presence", CoalesceKey: "presence:1"}
+	for _, message := range []*Message{stale, other, fresh} {
+		q.push(frame{message: message})
+	}
+
+	if len(q.frames) != 2 || q.frames[0].message != other || q.frames[1].message != fresh {
+		t.Errorf("queued %v, want the other user's presence followed by the fresh one", queuedTypes(q))
+	}
+	if _, _, _, coalesced := q.stats(); coalesced != 1 {
+		t.Errorf("coalesced = %d, want 1", coalesced)
+	}
+}
+
+func TestSendQueueDisconnect(t *testing.T) {
+	q := newSendQueue(1, DefaultSlowConsumerPolicies)
+
+	if !q.push(frame{message: &Message{Type: "new_message"}}) {
+		t.Fatal("first push asked to disconnect")
+	}
+	if q.push(frame{message: &Message{Type: "new_message"}}) {
+		t.Error("push to a full queue of disconnect events succeeded")
+	}
+}
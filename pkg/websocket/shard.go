@@ -0,0 +1,303 @@
+package websocket
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const shardControlSize = 256
+
+type shardOpKind int
+
+const (
+	opDeliver shardOpKind = iota
+	opSendToClient
+	opRegister
+	opUnregister
+	opClose
+	opResync
+)
+
+type shardOp struct {
+	kind    shardOpKind
+	userIDs []uint
+	seqs    map[uint]uint64
+	client  *Client
+	message *Message
+	code    int
+	reason  string
+}
+
+// shard owns the connections and event logs of a subset of users. All of its
+// state is only touched by its own goroutine, except clients, which IsOnline
+// reads under mu, and the overflow state that publishers record under
+// overflowMu.
+type shard struct {
+	hub        *Hub
+	clients    map[uint]map[*Client]bool
+	logs       map[uint]*eventLog
+	inbox      chan shardOp
+	control    chan shardOp
+	overflowed atomic.Bool
+	overflow   map[uint]uint64
+	overflowMu sync.Mutex
+	mu         sync.RWMutex
+}
+
+func newShard(hub *Hub, inboxSize int) *shard {
+	return &shard{
+		hub:     hub,
+		clients: make(map[uint]map[*Client]bool),
+		logs:    make(map[uint]*eventLog),
+		inbox:   make(chan shardOp, inboxSize),
+		control: make(chan shardOp, shardControlSize),
+	}
+}
+
+func (s *shard) run() {
+	sweep := time.NewTicker(eventLogSweep)
+	defer sweep.Stop()
+
+	for {
+		// Registrations go first so a resuming client is in place before the
+		// events that follow its catch-up.
+		select {
+		case op := <-s.control:
+			s.handle(op)
+			continue
+		default:
+		}
+
+		select {
+		case <-s.hub.done:
+			return
+		case now := <-sweep.C:
+			s.expireLogs(now)
+		case op := <-s.control:
+			s.handle(op)
+		case op := <-s.inbox:
+			s.handle(op)
+		}
+	}
+}
+
+// handle runs the op and then recovers from any overflow since the last one,
+// so that a client registered by the op is told to resync too.
+func (s *shard) handle(op shardOp) {
+	switch op.kind {
+	case opRegister:
+		s.register(op.client)
+	case opUnregister:
+		s.unregister(op.client)
+	case opClose:
+		s.disconnect(op.client, op.code, op.reason)
+	case opSendToClient:
+		if s.clients[op.client.userID][op.client] {
+			s.sendTo(op.client, frame{message: op.message})
+		}
+	case opDeliver:
+		s.deliver(op.userIDs, op.seqs, op.message)
+	case opResync:
+		s.resyncAll()
+	}
+
+	if s.overflowed.Load() {
+		s.recoverFromOverflow()
+	}
+}
+
+// register adds the client. Presence is tracked by the hub across the
+// cluster, not here.
+func (s *shard) register(client *Client) {
+	s.mu.Lock()
+	if s.clients[client.userID] == nil {
+		s.clients[client.userID] = make(map[*Client]bool)
+	}
+	s.clients[client.userID][client] = true
+	s.mu.Unlock()
+
+	s.resume(client)
+}
+
+func (s *shard) unregister(client *Client) {
+	s.disconnect(client, 0, "")
+}
+
+// disconnect removes the client and closes its connection with the given
+// close code, or with an empty close frame when code is 0. The client's
+// connection goroutine unregisters it once the connection ends.
+func (s *shard) disconnect(client *Client, code int, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if clients, ok := s.clients[client.userID]; ok {
+		if _, exists := clients[client]; exists {
+			delete(clients, client)
+			client.send.close(code, reason)
+			if len(clients) == 0 {
+				delete(s.clients, client.userID)
+			}
+		}
+	}
+}
+
+func (s *shard) deliver(userIDs []uint, seqs map[uint]uint64, message *Message) {
+	if userIDs == nil {
+		for userID := range s.clients {
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	now := time.Now()
+	for _, userID := range userIDs {
+		f := frame{message: message, seq: seqs[userID]}
+		if f.seq != 0 {
+			s.logEvent(userID, f, now)
+		}
+
+		for client := range s.clients[userID] {
+			s.sendTo(client, f)
+		}
+	}
+}
+
+func (s *shard) sendTo(client *Client, f frame) {
+	if !client.send.push(f) {
+		slowConsumerDisconnects.Add(1)
+		s.disconnect(client, websocket.CloseTryAgainLater, "slow consumer")
+	}
+}
+
+func (s *shard) clientStats() []ClientStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var stats []ClientStats
+	for userID, clients := range s.clients {
+		for client := range clients {
+			queued, highWater, dropped, coalesced := client.send.stats()
+			stats = append(stats, ClientStats{
+				UserID:    userID,
+				Queued:    queued,
+				HighWater: highWater,
+				Dropped:   dropped,
+				Coalesced: coalesced,
+			})
+		}
+	}
+	return stats
+}
+
+func (s *shard) isOnline(userID uint) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.clients[userID]) > 0
+}
+
+func (s *shard) logEvent(userID uint, f frame, now time.Time) {
+	log, ok := s.logs[userID]
+	if !ok {
+		log = newEventLog(f)
+		s.logs[userID] = log
+	}
+	log.append(f, now)
+}
+
+// expireLogs drops events past their retention and removes the logs left
+// empty. A client resuming without a log is checked against the current
+// sequence number of its stream instead.
+func (s *shard) expireLogs(now time.Time) {
+	for userID, log := range s.logs {
+		log.expire(now)
+		if len(log.events) == 0 {
+			delete(s.logs, userID)
+		}
+	}
+}
+
+// markOverflow records the recipients of a dropped event along with the
+// event's sequence number in their streams.
+func (s *shard) markOverflow(userIDs []uint, seqs map[uint]uint64) {
+	s.overflowMu.Lock()
+	defer s.overflowMu.Unlock()
+
+	if s.overflow == nil {
+		s.overflow = make(map[uint]uint64)
+	}
+	for _, userID := range userIDs {
+		s.overflow[userID] = max(s.overflow[userID], seqs[userID])
+	}
+	s.overflowed.Store(true)
+}
+
+// recoverFromOverflow runs after events for this shard were dropped. The
+// streams of their recipients now have a hole, so their logs are discarded and
+// their connected clients are told to resync from the dropped event on.
+func (s *shard) recoverFromOverflow() {
+	s.overflowMu.Lock()
+	overflow := s.overflow
+	s.overflow = nil
+	s.overflowed.Store(false)
+	s.overflowMu.Unlock()
+
+	for userID, seq := range overflow {
+		delete(s.logs, userID)
+
+		resync := frame{message: &Message{
+			Type: "resync_required",
+			Data: map[string]uint64{"seq": seq},
+		}}
+		for client := range s.clients[userID] {
+			s.sendTo(client, resync)
+		}
+	}
+}
+
+// resyncAll runs when the backplane may have missed events for this node
+// without knowing whose. Every log may have a hole, so all of them are
+// discarded and every connected client is told to resync, with no seq since
+// the missed events are unknown.
+func (s *shard) resyncAll() {
+	s.logs = make(map[uint]*eventLog)
+
+	resync := frame{message: &Message{Type: "resync_required", Data: map[string]uint64{}}}
+	for _, clients := range s.clients {
+		for client := range clients {
+			s.sendTo(client, resync)
+		}
+	}
+}
+
+// resume replays the events a reconnecting client missed, or asks it to
+// resync over HTTP when they are no longer in the log. Without a log the
+// client can only be up to date if it has seen the stream's current sequence
+// number, as read by Register.
+func (s *shard) resume(client *Client) {
+	seq := client.seq
+	log, hasLog := s.logs[client.userID]
+	if hasLog {
+		seq = log.lastSeq()
+	}
+	ready := frame{message: &Message{Type: "ready", Data: map[string]uint64{"seq": seq}}}
+
+	if client.since == 0 {
+		s.sendTo(client, ready)
+		return
+	}
+
+	var missed []frame
+	ok := client.since == seq
+	if hasLog {
+		missed, ok = log.since(client.since)
+	}
+
+	if !ok || !client.send.pushAll(append(missed, ready)) {
+		s.sendTo(client, frame{message: &Message{
+			Type: "resync_required",
+			Data: map[string]uint64{"since": client.since, "seq": seq},
+		}})
+	}
+}
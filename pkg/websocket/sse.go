@@ -0,0 +1,118 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const sseHeartbeatPeriod = 15 * time.Second
+
+// NewSSEClient creates a client whose events are streamed over Server-Sent
+// Events by ServeSSE instead of a websocket connection. Frames are always
+// JSON; the sequence number of an event becomes its SSE id so that
+// Last-Event-ID resumes the stream.
+func NewSSEClient(hub *Hub, userID uint, since uint64, expiresAt time.Time) *Client {
+	return NewClient(hub, nil, userID, since, expiresAt)
+}
+
+// ServeSSE registers the client and streams its events until the request is
+// cancelled, the hub drops the client or its token expires. Heartbeat comments
+// keep proxies from closing an idle stream.
+func (c *Client) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	c.hub.Register(c)
+	defer c.hub.Unregister(c)
+
+	heartbeat := time.NewTicker(sseHeartbeatPeriod)
+	expiry := time.NewTimer(0)
+	expiry.Stop()
+	defer func() {
+		heartbeat.Stop()
+		expiry.Stop()
+	}()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case expiresAt := <-c.expiresAt:
+			expiry.Stop()
+			if !expiresAt.IsZero() {
+				expiry.Reset(time.Until(expiresAt))
+			}
+
+		case <-expiry.C:
+			code, reason := c.hub.expiryClose(c.userID)
+			writeSSEClose(w, code, reason)
+			rc.Flush()
+			return
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+
+		case <-c.send.ready:
+			frames, closed, code, reason := c.send.drain()
+			if closed {
+				if code != 0 {
+					writeSSEClose(w, code, reason)
+					rc.Flush()
+				}
+				return
+			}
+
+			written := make([]*Message, 0, len(frames))
+			for _, f := range frames {
+				data, err := f.encode(c.codec)
+				if err != nil {
+					continue
+				}
+				if f.seq != 0 {
+					fmt.Fprintf(w, "id: %d\n", f.seq)
+				}
+				if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+					return
+				}
+				written = append(written, f.message)
+			}
+
+			if err := rc.Flush(); err != nil {
+				return
+			}
+
+			for _, msg := range written {
+				if msg.needsDeliveryReceipt(c.userID) {
+					c.hub.reportDelivered(c.userID, msg.Message.ChatID, msg.Message.ID)
+				}
+			}
+		}
+	}
+}
+
+// writeSSEClose sends the close code and reason a websocket client would get
+// in its close frame, as a "close" event.
+func writeSSEClose(w http.ResponseWriter, code int, reason string) {
+	data, _ := json.Marshal(map[string]interface{}{"code": code, "reason": reason})
+	fmt.Fprintf(w, "event: close\ndata: %s\n\n", data)
+}